@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// apiPost is how posts are described by the json api. Content is only set
+// when a single post is requested.
+type apiPost struct {
+	Title       string     `json:"title"`
+	Slug        string     `json:"slug"`
+	URL         string     `json:"url"`
+	PublishedAt time.Time  `json:"publishedAt"`
+	UpdatedAt   *time.Time `json:"updatedAt,omitempty"`
+	Tags        []string   `json:"tags"`
+	Summary     string     `json:"summary"`
+	Content     string     `json:"content,omitempty"`
+}
+
+func newAPIPost(cfg *Config, p *post) apiPost {
+	a := apiPost{
+		Title:       p.Title,
+		Slug:        p.Slug,
+		URL:         cfg.BaseURL + "/blog/" + p.Slug,
+		PublishedAt: p.PublishedAt,
+		Tags:        p.Tags,
+		Summary:     p.Summary,
+	}
+	if !p.UpdatedAt.IsZero() {
+		a.UpdatedAt = &p.UpdatedAt
+	}
+	if a.Tags == nil {
+		a.Tags = []string{}
+	}
+	return a
+}
+
+// registerAPI serves the posts as json, so other clients can be built on
+// top of the site. Only the visible posts are included, the same as the
+// html pages.
+func (s *Server) registerAPI() {
+	s.mux.HandleFunc("GET /api/posts", func(w http.ResponseWriter, r *http.Request) {
+		c := s.store.forRequest(r)
+		posts := make([]apiPost, len(c.posts))
+		for i, p := range c.posts {
+			posts[i] = newAPIPost(s.cfg, p)
+		}
+		writeJSON(w, http.StatusOK, posts)
+	})
+	s.mux.HandleFunc("GET /api/posts/{slug}", func(w http.ResponseWriter, r *http.Request) {
+		p := s.findPost(r, s.store.forRequest(r))
+		if p == nil {
+			writeJSON(w, http.StatusNotFound, struct {
+				Error string `json:"error"`
+			}{"post not found"})
+			return
+		}
+		post := newAPIPost(s.cfg, p)
+		post.Content = string(p.Content)
+		writeJSON(w, http.StatusOK, post)
+	})
+}
+
+// writeJSON writes v out as the response. Which other sites can fetch it
+// is up to the cors middleware.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAPI(t *testing.T) {
+	fsys := testFS()
+	fsys["posts/draft-post.md"] = &fstest.MapFile{Data: []byte(`---
+title: "Draft Post"
+published: "Mar 04 2023 PST"
+draft: true
+---
+
+Not quite ready.
+`)}
+	fsys["posts/future-post.md"] = &fstest.MapFile{Data: []byte(`---
+title: "Future Post"
+published: "2999-01-01"
+---
+
+Not yet.
+`)}
+	cfg := *testConfig
+	cfg.PreviewSecret = "secret"
+	srv := newTestServer(t, &cfg, fsys)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("GET %s: Content-Type = %q", path, ct)
+		}
+		return rec
+	}
+
+	rec := get("/api/posts")
+	var posts []apiPost
+	if err := json.Unmarshal(rec.Body.Bytes(), &posts); err != nil {
+		t.Fatalf("decoding /api/posts: %v", err)
+	}
+	var slugs []string
+	for _, p := range posts {
+		slugs = append(slugs, p.Slug)
+		if p.Content != "" {
+			t.Errorf("/api/posts includes the content of %s", p.Slug)
+		}
+	}
+	if got := strings.Join(slugs, " "); got != "second-post first-post" {
+		t.Errorf("/api/posts = %s, want second-post first-post", got)
+	}
+	if second := posts[0]; second.Title != "Second Post" || strings.Join(second.Tags, ",") != "go" || second.URL != "https://morgangallant.com/blog/second-post" {
+		t.Errorf("/api/posts[0] = %+v", second)
+	}
+	if !strings.Contains(rec.Body.String(), `"tags":[]`) {
+		t.Errorf("posts without tags should have an empty list of them: %s", rec.Body)
+	}
+
+	rec = get("/api/posts/first-post")
+	var first apiPost
+	if err := json.Unmarshal(rec.Body.Bytes(), &first); err != nil {
+		t.Fatalf("decoding /api/posts/first-post: %v", err)
+	}
+	if rec.Code != http.StatusOK || first.Title != "First Post" || !strings.Contains(first.Content, "Hello from the first post.") {
+		t.Errorf("GET /api/posts/first-post = %d %+v", rec.Code, first)
+	}
+
+	for path, want := range map[string]int{
+		"/api/posts/does-not-exist": http.StatusNotFound,
+		"/api/posts/future-post":    http.StatusNotFound,
+		"/api/posts/draft-post":     http.StatusNotFound,
+		"/api/posts/draft-post?preview=" + previewToken("secret", "draft-post"): http.StatusOK,
+	} {
+		if rec := get(path); rec.Code != want {
+			t.Errorf("GET %s = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"text/template/parse"
+)
+
+const (
+	// publicCacheControl is sent along with public files requested by their
+	// plain path. These only change when the binary is rebuilt, at which
+	// point the content-hashed ETag changes too.
+	publicCacheControl = "public, max-age=86400"
+	// hashedCacheControl is sent along with fingerprinted files, the url
+	// changes whenever the content does so these can be cached forever.
+	hashedCacheControl = "public, max-age=31536000, immutable"
+)
+
+// assetManifest maps the logical names of static assets (i.e. "styles.css")
+// to the urls they should be referenced by. In production these urls contain
+// a hash of the file content, so they change whenever the file does.
+type assetManifest struct {
+	urls map[string]string
+	// prefix is the path assets are served under, empty for the root.
+	prefix string
+}
+
+// url resolves the url of a static asset, and is exposed to templates as
+// the "asset" function.
+func (m *assetManifest) url(name string) (string, error) {
+	u, ok := m.urls[strings.TrimPrefix(name, "/")]
+	if !ok {
+		return "", fmt.Errorf("unknown asset %s", name)
+	}
+	return u, nil
+}
+
+// has reports whether there's an asset called name.
+func (m *assetManifest) has(name string) bool {
+	_, ok := m.urls[name]
+	return ok
+}
+
+// serves reports whether p is the url of one of the assets.
+func (m *assetManifest) serves(p string) bool {
+	for _, u := range m.urls {
+		if u == p {
+			return true
+		}
+	}
+	return false
+}
+
+// register adds an asset to the manifest and serves it via handler under
+// both its plain and fingerprinted paths. In dev, only the plain path is
+// used and caching is disabled since files may change underneath us.
+func (m *assetManifest) register(mux *http.ServeMux, name string, content []byte, handler http.HandlerFunc) {
+	plain := m.prefix + "/" + name
+	if !production() {
+		m.urls[name] = plain
+		mux.HandleFunc("GET "+plain, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "no-cache")
+			handler(w, r)
+		})
+		return
+	}
+
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:8])
+	etag := `"` + hash + `"`
+	ext := path.Ext(plain)
+	hashed := strings.TrimSuffix(plain, ext) + "." + hash + ext
+
+	m.urls[name] = hashed
+	mux.HandleFunc("GET "+plain, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", publicCacheControl)
+		handler(w, r)
+	})
+	mux.HandleFunc("GET "+hashed, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", hashedCacheControl)
+		handler(w, r)
+	})
+}
+
+// validate makes sure that every asset referenced by name from the
+// template exists, so typos are caught at startup rather than when a page is
+// rendered.
+func (m *assetManifest) validate(tmpl *template.Template) error {
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		var missing error
+		walkTemplateNodes(t.Tree.Root, func(node parse.Node) {
+			cmd, ok := node.(*parse.CommandNode)
+			if !ok || missing != nil || len(cmd.Args) != 2 {
+				return
+			}
+			ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+			if !ok || ident.Ident != "asset" {
+				return
+			}
+			name, ok := cmd.Args[1].(*parse.StringNode)
+			if !ok {
+				return
+			}
+			if _, err := m.url(name.Text); err != nil {
+				missing = fmt.Errorf("template %s: %w", t.Name(), err)
+			}
+		})
+		if missing != nil {
+			return missing
+		}
+	}
+	return nil
+}
+
+// walkTemplateNodes calls fn for node and every node below it.
+func walkTemplateNodes(node parse.Node, fn func(parse.Node)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkTemplateNodes(child, fn)
+		}
+	case *parse.ActionNode:
+		walkTemplateNodes(n.Pipe, fn)
+	case *parse.IfNode:
+		walkTemplateNodes(n.Pipe, fn)
+		walkTemplateNodes(n.List, fn)
+		walkTemplateNodes(n.ElseList, fn)
+	case *parse.RangeNode:
+		walkTemplateNodes(n.Pipe, fn)
+		walkTemplateNodes(n.List, fn)
+		walkTemplateNodes(n.ElseList, fn)
+	case *parse.WithNode:
+		walkTemplateNodes(n.Pipe, fn)
+		walkTemplateNodes(n.List, fn)
+		walkTemplateNodes(n.ElseList, fn)
+	case *parse.TemplateNode:
+		fn(n)
+		walkTemplateNodes(n.Pipe, fn)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			walkTemplateNodes(cmd, fn)
+		}
+	case *parse.CommandNode:
+		fn(n)
+		for _, arg := range n.Args {
+			walkTemplateNodes(arg, fn)
+		}
+	}
+}
+
+// registerPublicDir serves every file under public/ in fsys under prefix,
+// e.g. /assets, and returns a manifest of them for use in templates.
+func registerPublicDir(mux *http.ServeMux, fsys fs.FS, prefix string) (*assetManifest, error) {
+	const dirPath = "public"
+	if err := registerMIMETypes(); err != nil {
+		return nil, err
+	}
+	assets := &assetManifest{urls: make(map[string]string), prefix: prefix}
+	if err := fs.WalkDir(
+		fsys,
+		dirPath,
+		func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			} else if d.IsDir() {
+				return nil
+			}
+			content, err := fs.ReadFile(fsys, path)
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", path, err)
+			}
+			trimmed := strings.TrimPrefix(path, dirPath+"/")
+			assets.register(mux, trimmed, content, func(w http.ResponseWriter, r *http.Request) {
+				http.ServeFileFS(w, r, fsys, path)
+			})
+			return nil
+		},
+	); err != nil {
+		return nil, err
+	}
+	return assets, nil
+}
+
+// mimeTypes are the types of files under public/ which the system's mime
+// database (or the one built into Go) may not know about, or get wrong, in
+// which case they'd be sent without the right Content-Type.
+var mimeTypes = map[string]string{
+	".avif":        "image/avif",
+	".webp":        "image/webp",
+	".svg":         "image/svg+xml",
+	".ico":         "image/x-icon",
+	".woff":        "font/woff",
+	".woff2":       "font/woff2",
+	".mjs":         "text/javascript; charset=utf-8",
+	".wasm":        "application/wasm",
+	".webmanifest": "application/manifest+json",
+}
+
+// registerMIMETypes adds mimeTypes to the mime package, which is what
+// http.ServeFileFS uses to set the Content-Type from the file's extension.
+func registerMIMETypes() error {
+	for ext, typ := range mimeTypes {
+		if err := mime.AddExtensionType(ext, typ); err != nil {
+			return fmt.Errorf("registering mime type of %s: %w", ext, err)
+		}
+	}
+	return nil
+}
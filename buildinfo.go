@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// revision can be set with -ldflags "-X main.revision=...", for builds
+// where the go command can't stamp the version control info itself, e.g.
+// when .git isn't copied into the build context.
+var revision string
+
+// buildInfo identifies which build of the site is running.
+type buildInfo struct {
+	Revision string `json:"revision"`
+	// Time is when the revision was committed, empty if unknown.
+	Time     string `json:"time,omitempty"`
+	Modified bool   `json:"modified,omitempty"`
+}
+
+// readBuildInfo reads the version control info the go command stamps into
+// binaries. It isn't there for go run or builds outside of a repository, so
+// the revision falls back to "unknown".
+func readBuildInfo() buildInfo {
+	info := buildInfo{Revision: revision}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Revision == "" {
+					info.Revision = s.Value
+				}
+			case "vcs.time":
+				if t, err := time.Parse(time.RFC3339, s.Value); err == nil {
+					info.Time = t.UTC().Format(time.RFC3339)
+				}
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	if info.Revision == "" {
+		info.Revision = "unknown"
+	}
+	return info
+}
+
+// String is the short form sent in the X-Build header, like the output of
+// git describe --always --dirty.
+func (b buildInfo) String() string {
+	s := b.Revision
+	if len(s) > 12 {
+		s = s[:12]
+	}
+	if b.Modified {
+		s += "-dirty"
+	}
+	return s
+}
+
+func (b buildInfo) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("revision", b.Revision),
+		slog.String("time", b.Time),
+		slog.Bool("modified", b.Modified),
+	)
+}
+
+// buildHeader sets the X-Build header on every response, so it's easy to
+// tell which build served a request while a deploy is rolling out.
+func buildHeader(build buildInfo, next http.Handler) http.Handler {
+	value := build.String()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Build", value)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBuildInfoString(t *testing.T) {
+	for _, tc := range []struct {
+		info buildInfo
+		want string
+	}{
+		{buildInfo{Revision: "unknown"}, "unknown"},
+		{buildInfo{Revision: "0123456789abcdef0123"}, "0123456789ab"},
+		{buildInfo{Revision: "0123456789abcdef0123", Modified: true}, "0123456789ab-dirty"},
+	} {
+		if got := tc.info.String(); got != tc.want {
+			t.Errorf("%+v.String() = %q, want %q", tc.info, got, tc.want)
+		}
+	}
+}
+
+func TestBuildHeader(t *testing.T) {
+	srv := newTestServer(t, testConfig, testFS())
+	srv.build = buildInfo{Revision: "0123456789abcdef", Time: "2024-01-02T03:04:05Z"}
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if got := rec.Header().Get("X-Build"); got != "0123456789ab" {
+		t.Errorf("X-Build = %q, want 0123456789ab", got)
+	}
+	var health struct {
+		Build buildInfo `json:"build"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &health); err != nil {
+		t.Fatalf("decoding /healthz: %v", err)
+	}
+	if health.Build != srv.build {
+		t.Errorf("/healthz build = %+v, want %+v", health.Build, srv.build)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::1/128")}
+	for _, tc := range []struct {
+		name, remoteAddr, forwardedFor, realIP string
+		trusted                                []netip.Prefix
+		want                                   string
+	}{
+		{"direct", "192.0.2.1:1234", "", "", trusted, "192.0.2.1"},
+		{"untrusted peer", "192.0.2.1:1234", "198.51.100.1", "198.51.100.2", trusted, "192.0.2.1"},
+		{"no trusted proxies", "10.0.0.1:1234", "198.51.100.1", "", nil, "10.0.0.1"},
+		{"trusted peer", "10.0.0.1:1234", "198.51.100.1", "", trusted, "198.51.100.1"},
+		{"forged hops", "10.0.0.1:1234", "203.0.113.9, 198.51.100.1", "", trusted, "198.51.100.1"},
+		{"chained proxies", "10.0.0.1:1234", "198.51.100.1, 10.0.0.2", "", trusted, "198.51.100.1"},
+		{"ipv6 proxy", "[2001:db8::1]:1234", "198.51.100.1", "", trusted, "198.51.100.1"},
+		{"real ip", "10.0.0.1:1234", "", "198.51.100.1", trusted, "198.51.100.1"},
+		{"garbage", "10.0.0.1:1234", "not-an-ip", "also-not", trusted, "10.0.0.1"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remoteAddr
+		if tc.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		}
+		if tc.realIP != "" {
+			req.Header.Set("X-Real-IP", tc.realIP)
+		}
+		var got string
+		resolveClientIP(tc.trusted, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = clientIP(r)
+		})).ServeHTTP(httptest.NewRecorder(), req)
+		if got != tc.want {
+			t.Errorf("%s: client ip = %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the metadata about the site which is used by the feeds,
+// meta tags and templates, along with the http server settings. Every field
+// can be overridden via the environment variable next to it.
+type Config struct {
+	SiteTitle   string // SITE_TITLE
+	Author      string // SITE_AUTHOR
+	AuthorEmail string // SITE_AUTHOR_EMAIL
+	BaseURL     string // SITE_BASE_URL
+	Description string // SITE_DESCRIPTION
+	// Image is the name of a file under public/ used as the og:image of
+	// pages which don't have one of their own, empty for none.
+	Image string // SITE_IMAGE
+	// Icon is the name of a file under public/ used as the favicon and in
+	// the web app manifest, empty for none.
+	Icon string // SITE_ICON
+	// AssetPrefix is the path the files under public/ are served under, like
+	// /assets, so they can be told apart from pages, i.e. by a CDN. They're
+	// served from the root by default. Note that links to them from posts
+	// must include it.
+	AssetPrefix string // ASSET_PREFIX
+	// ThemeColor is the colour browsers may tint their ui with, as a hex
+	// colour like #ffffff.
+	ThemeColor string // SITE_THEME_COLOR
+
+	// ContentSecurityPolicy is sent with every response. Code blocks are
+	// highlighted using classes rather than inline styles, so the default
+	// doesn't need to allow any inline styles or scripts.
+	ContentSecurityPolicy string // SITE_CSP
+
+	// AnchorLevel is the deepest level of heading in posts which gets a
+	// link to itself, from 2 for only h2s to 6.
+	AnchorLevel int // HEADING_ANCHOR_LEVEL
+
+	// TagOrder is how the tags are sorted on /blog/tags, either "name" or
+	// "count" for the most common first.
+	TagOrder string // TAG_ORDER
+
+	// PostsPerPage is how many posts are listed on each page of /blog.
+	PostsPerPage int // POSTS_PER_PAGE
+
+	// AllowCrawling controls whether robots.txt lets crawlers index the
+	// site. It defaults to true in production only, set it to false so
+	// that a staging deploy doesn't end up in search results.
+	AllowCrawling bool // SITE_ALLOW_CRAWLING
+	// StrictLinks makes broken internal links in posts fail startup, rather
+	// than only being logged. Meant for production, so dead links are caught
+	// before a deploy goes live.
+	StrictLinks bool // STRICT_LINKS
+	// StrictFrontmatter makes keys in the frontmatter of posts which aren't
+	// used for anything an error, rather than a warning, since they're most
+	// likely typos.
+	StrictFrontmatter bool // STRICT_FRONTMATTER
+	// SourceFrontmatter keeps the frontmatter in the markdown source of
+	// posts served at /blog/{slug}.md, otherwise only the body is served.
+	SourceFrontmatter bool // SOURCE_FRONTMATTER
+
+	// Host is the address to listen on, empty for all interfaces. Use
+	// 127.0.0.1 when running behind a local reverse proxy, or :: for all
+	// IPv6 interfaces.
+	Host string // HOST
+	Port uint16 // PORT
+
+	// MetricsEnabled serves request counts and latencies for Prometheus
+	// at /metrics. If MetricsAddr is set, they're served there rather than
+	// on the public address, e.g. 127.0.0.1:9090.
+	MetricsEnabled bool   // METRICS_ENABLED
+	MetricsAddr    string // METRICS_ADDR
+
+	// RateLimit is how many requests per second each client can make to
+	// the pages and other dynamic routes, after an initial burst of
+	// RateBurst. Zero disables rate limiting.
+	RateLimit float64 // RATE_LIMIT
+	RateBurst int     // RATE_BURST
+	// AllowedOrigins are the origins, like https://example.com, which can
+	// fetch the api and feeds from the browser, as a comma-separated list.
+	// Use * to allow any origin, or leave it empty for same-origin only.
+	AllowedOrigins []string // ALLOWED_ORIGINS
+	// MaxBodySize is how many bytes the body of a request can be, requests
+	// with a larger one get a 413.
+	MaxBodySize int64 // MAX_BODY_SIZE
+	// TrustedProxies are the addresses of the reverse proxies in front of
+	// the server, as a comma-separated list of CIDRs or ips. Requests from
+	// them are attributed to the client in X-Forwarded-For or X-Real-IP.
+	TrustedProxies []netip.Prefix // TRUSTED_PROXIES
+	// Maintenance serves a 503 maintenance page for everything but the
+	// health checks, to clients outside of MaintenanceAllow, which is a
+	// comma-separated list of CIDRs or ips. It can be toggled without a
+	// restart by changing .env and sending the process a SIGHUP, see
+	// reloadMaintenance.
+	Maintenance      bool           // MAINTENANCE
+	MaintenanceAllow []netip.Prefix // MAINTENANCE_ALLOW
+
+	// LogLevel is one of DEBUG, INFO, WARN or ERROR, and is ignored in dev
+	// where everything is logged. LogFormat is json or text, and defaults to
+	// json in production only. If LogFile is set, logs are appended to it as
+	// well as being written to stdout.
+	LogLevel  slog.Level // LOG_LEVEL
+	LogFormat string     // LOG_FORMAT
+	LogFile   string     // LOG_FILE
+
+	// DateLayout is the time layout of the published and updated dates in
+	// the frontmatter of posts. RFC 3339 timestamps and plain dates like
+	// 2006-01-02 are accepted as well, see parsePostDate.
+	DateLayout string // POST_DATE_LAYOUT
+
+	// PreviewSecret is used to sign the links to previews of drafts, which
+	// are otherwise hidden in production, see previewToken. Previews are
+	// disabled if it's empty.
+	PreviewSecret string // PREVIEW_SECRET
+
+	// ContentDir is a directory laid out like static/ to read the site files
+	// from, rather than the copy embedded in the binary, so posts can be
+	// added and picked up with a SIGHUP instead of a deploy. Files under
+	// public/ are only read at startup though, so new or changed ones, like
+	// the cover image of a new post, need a restart.
+	ContentDir string // CONTENT_DIR
+
+	// WebmentionsFile is where accepted webmentions are kept, as json lines.
+	// They're only kept in memory if it's empty.
+	WebmentionsFile string // WEBMENTIONS_FILE
+
+	// RequestTimeout is how long handlers have before the context of the
+	// request is cancelled, so anything they're waiting on gives up before
+	// the WriteTimeout cuts the connection.
+	RequestTimeout    time.Duration // REQUEST_TIMEOUT
+	ReadTimeout       time.Duration // READ_TIMEOUT
+	ReadHeaderTimeout time.Duration // READ_HEADER_TIMEOUT
+	WriteTimeout      time.Duration // WRITE_TIMEOUT
+	IdleTimeout       time.Duration // IDLE_TIMEOUT
+	ShutdownTimeout   time.Duration // SHUTDOWN_TIMEOUT
+}
+
+func loadConfig() (*Config, error) {
+	var errs []error
+	duration := func(key string, fallback time.Duration) time.Duration {
+		raw, ok := os.LookupEnv(key)
+		if !ok {
+			return fallback
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("parsing %s: %w", key, err))
+		}
+		return d
+	}
+	boolean := func(key string, fallback bool) bool {
+		raw, ok := os.LookupEnv(key)
+		if !ok {
+			return fallback
+		}
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("parsing %s: %w", key, err))
+		}
+		return b
+	}
+
+	// prefixes parses a comma-separated list of CIDRs, where a plain ip is
+	// taken to mean just that address.
+	prefixes := func(key string) []netip.Prefix {
+		var out []netip.Prefix
+		for _, raw := range strings.Split(os.Getenv(key), ",") {
+			raw = strings.TrimSpace(raw)
+			if raw == "" {
+				continue
+			}
+			prefix, err := netip.ParsePrefix(raw)
+			if err != nil {
+				addr, addrErr := netip.ParseAddr(raw)
+				if addrErr != nil {
+					errs = append(errs, fmt.Errorf("parsing %s: %w", key, err))
+					continue
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			out = append(out, prefix.Masked())
+		}
+		return out
+	}
+
+	var port uint16 = 8080
+	if raw, ok := os.LookupEnv("PORT"); ok {
+		parsed, err := strconv.ParseUint(raw, 10, 16)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("parsing PORT: %w", err))
+		}
+		port = uint16(parsed)
+	}
+
+	number := func(key string, fallback float64) float64 {
+		raw, ok := os.LookupEnv(key)
+		if !ok {
+			return fallback
+		}
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("parsing %s: %w", key, err))
+		}
+		return f
+	}
+
+	logFormat := "text"
+	if production() {
+		logFormat = "json"
+	}
+
+	cfg := &Config{
+		SiteTitle:   envOr("SITE_TITLE", "Morgan Gallant"),
+		Author:      envOr("SITE_AUTHOR", "Morgan Gallant"),
+		AuthorEmail: envOr("SITE_AUTHOR_EMAIL", "morgan@morgangallant.com"),
+		BaseURL:     strings.TrimSuffix(envOr("SITE_BASE_URL", "https://morgangallant.com"), "/"),
+		Description: envOr("SITE_DESCRIPTION", "Ramblings about technology, software... and probably some other stuff too"),
+		Image:       strings.TrimPrefix(os.Getenv("SITE_IMAGE"), "/"),
+		Icon:        strings.TrimPrefix(envOr("SITE_ICON", "favicon.svg"), "/"),
+		AssetPrefix: "/" + strings.Trim(os.Getenv("ASSET_PREFIX"), "/"),
+		ThemeColor:  envOr("SITE_THEME_COLOR", "#ffffff"),
+		ContentSecurityPolicy: envOr("SITE_CSP", strings.Join([]string{
+			"default-src 'self'",
+			"img-src 'self' https: data:",
+			"object-src 'none'",
+			"base-uri 'self'",
+			"form-action 'self'",
+			"frame-ancestors 'none'",
+		}, "; ")),
+		AnchorLevel:       int(number("HEADING_ANCHOR_LEVEL", 3)),
+		TagOrder:          envOr("TAG_ORDER", "name"),
+		PostsPerPage:      int(number("POSTS_PER_PAGE", 10)),
+		AllowCrawling:     boolean("SITE_ALLOW_CRAWLING", production()),
+		Host:              strings.Trim(os.Getenv("HOST"), "[]"),
+		Port:              port,
+		MetricsEnabled:    boolean("METRICS_ENABLED", false),
+		MetricsAddr:       os.Getenv("METRICS_ADDR"),
+		LogLevel:          logLevels[os.Getenv("LOG_LEVEL")],
+		LogFormat:         envOr("LOG_FORMAT", logFormat),
+		LogFile:           os.Getenv("LOG_FILE"),
+		DateLayout:        envOr("POST_DATE_LAYOUT", "Jan 02 2006 MST"),
+		PreviewSecret:     os.Getenv("PREVIEW_SECRET"),
+		ContentDir:        os.Getenv("CONTENT_DIR"),
+		WebmentionsFile:   os.Getenv("WEBMENTIONS_FILE"),
+		RateLimit:         number("RATE_LIMIT", 5),
+		RateBurst:         int(number("RATE_BURST", 20)),
+		MaxBodySize:       int64(number("MAX_BODY_SIZE", 64<<10)),
+		TrustedProxies:    prefixes("TRUSTED_PROXIES"),
+		Maintenance:       boolean("MAINTENANCE", false),
+		MaintenanceAllow:  prefixes("MAINTENANCE_ALLOW"),
+		StrictLinks:       boolean("STRICT_LINKS", false),
+		StrictFrontmatter: boolean("STRICT_FRONTMATTER", false),
+		SourceFrontmatter: boolean("SOURCE_FRONTMATTER", true),
+		RequestTimeout:    duration("REQUEST_TIMEOUT", time.Second*8),
+		ReadTimeout:       duration("READ_TIMEOUT", time.Second*10),
+		ReadHeaderTimeout: duration("READ_HEADER_TIMEOUT", time.Second*5),
+		WriteTimeout:      duration("WRITE_TIMEOUT", time.Second*10),
+		IdleTimeout:       duration("IDLE_TIMEOUT", time.Minute*2),
+		ShutdownTimeout:   duration("SHUTDOWN_TIMEOUT", time.Second*5),
+	}
+	if cfg.AssetPrefix == "/" {
+		cfg.AssetPrefix = ""
+	}
+	for _, origin := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSuffix(strings.TrimSpace(origin), "/"); origin != "" {
+			cfg.AllowedOrigins = append(cfg.AllowedOrigins, origin)
+		}
+	}
+	if err := errors.Join(append(errs, cfg.validate())...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+var logLevels = map[string]slog.Level{
+	"DEBUG": slog.LevelDebug,
+	"INFO":  slog.LevelInfo,
+	"WARN":  slog.LevelWarn,
+	"ERROR": slog.LevelError,
+}
+
+// envOr returns the value of the environment variable key, or fallback if
+// it isn't set. Variables which are set but empty are returned as-is, so
+// that validation can catch them.
+func envOr(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
+
+func (c *Config) validate() error {
+	var errs []error
+	for _, field := range []struct {
+		name, value string
+	}{
+		{"SITE_TITLE", c.SiteTitle},
+		{"SITE_AUTHOR", c.Author},
+		{"SITE_AUTHOR_EMAIL", c.AuthorEmail},
+		{"SITE_BASE_URL", c.BaseURL},
+		{"SITE_DESCRIPTION", c.Description},
+		{"POST_DATE_LAYOUT", c.DateLayout},
+	} {
+		if strings.TrimSpace(field.value) == "" {
+			errs = append(errs, fmt.Errorf("%s must not be empty", field.name))
+		}
+	}
+	for _, field := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"REQUEST_TIMEOUT", c.RequestTimeout},
+		{"READ_TIMEOUT", c.ReadTimeout},
+		{"READ_HEADER_TIMEOUT", c.ReadHeaderTimeout},
+		{"WRITE_TIMEOUT", c.WriteTimeout},
+		{"IDLE_TIMEOUT", c.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT", c.ShutdownTimeout},
+	} {
+		if field.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", field.name))
+		}
+	}
+	if c.RateLimit < 0 || math.IsNaN(c.RateLimit) || math.IsInf(c.RateLimit, 0) {
+		errs = append(errs, errors.New("RATE_LIMIT must be a non-negative number"))
+	} else if c.RateLimit > 0 && c.RateBurst < 1 {
+		errs = append(errs, errors.New("RATE_BURST must be at least 1"))
+	}
+	if c.RequestTimeout > 0 && c.WriteTimeout > 0 && c.RequestTimeout >= c.WriteTimeout {
+		errs = append(errs, errors.New("REQUEST_TIMEOUT must be shorter than WRITE_TIMEOUT"))
+	}
+	if c.AnchorLevel < 2 || c.AnchorLevel > 6 {
+		errs = append(errs, errors.New("HEADING_ANCHOR_LEVEL must be from 2 to 6"))
+	}
+	if c.TagOrder != "name" && c.TagOrder != "count" {
+		errs = append(errs, fmt.Errorf("TAG_ORDER %s must be name or count", c.TagOrder))
+	}
+	if c.PostsPerPage < 1 {
+		errs = append(errs, errors.New("POSTS_PER_PAGE must be at least 1"))
+	}
+	if c.MaxBodySize < 1 {
+		errs = append(errs, errors.New("MAX_BODY_SIZE must be at least 1"))
+	}
+	if c.LogFormat != "json" && c.LogFormat != "text" {
+		errs = append(errs, fmt.Errorf("LOG_FORMAT %s must be json or text", c.LogFormat))
+	}
+	if !assetPrefix.MatchString(c.AssetPrefix) {
+		errs = append(errs, fmt.Errorf("ASSET_PREFIX %s must be a path like /assets", c.AssetPrefix))
+	}
+	if c.ThemeColor != "" && !hexColor.MatchString(c.ThemeColor) {
+		errs = append(errs, fmt.Errorf("SITE_THEME_COLOR %s must be a hex colour like #ffffff", c.ThemeColor))
+	}
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			errs = append(errs, fmt.Errorf("ALLOWED_ORIGINS %s must be an origin like https://example.com", origin))
+		}
+	}
+	if c.Host != "" && net.ParseIP(c.Host) == nil {
+		errs = append(errs, fmt.Errorf("HOST %s must be an ip address", c.Host))
+	}
+	if c.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddr); err != nil {
+			errs = append(errs, fmt.Errorf("parsing METRICS_ADDR: %w", err))
+		} else if !c.MetricsEnabled {
+			errs = append(errs, errors.New("METRICS_ADDR is set, but METRICS_ENABLED isn't"))
+		}
+	}
+	if c.BaseURL != "" {
+		u, err := url.Parse(c.BaseURL)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("parsing SITE_BASE_URL: %w", err))
+		} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("SITE_BASE_URL %s must be an absolute http(s) url", c.BaseURL))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var (
+	hexColor    = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+	assetPrefix = regexp.MustCompile(`^(/[a-zA-Z0-9._-]+)*$`)
+)
+
+// publicURL is the absolute url of the file name under public/, or empty if
+// name is.
+func (c *Config) publicURL(name string) string {
+	if name == "" {
+		return ""
+	}
+	return c.BaseURL + c.AssetPrefix + "/" + name
+}
+
+// Addr is the address for the http server to listen on.
+func (c *Config) Addr() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(int(c.Port)))
+}
+
+// ImageURL is the absolute url of Image, for use in templates.
+func (c *Config) ImageURL() string {
+	return c.publicURL(c.Image)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigAddr(t *testing.T) {
+	for _, tc := range []struct {
+		host, port string
+		want       string
+		wantErr    string
+	}{
+		{"", "", ":8080", ""},
+		{"127.0.0.1", "3000", "127.0.0.1:3000", ""},
+		{"::", "8080", "[::]:8080", ""},
+		{"[::1]", "8080", "[::1]:8080", ""},
+		{"example.com", "8080", "", "HOST example.com must be an ip address"},
+		{"", "http", "", "parsing PORT"},
+		{"", "70000", "", "parsing PORT"},
+	} {
+		t.Setenv("HOST", tc.host)
+		if tc.port == "" {
+			t.Setenv("PORT", "8080")
+		} else {
+			t.Setenv("PORT", tc.port)
+		}
+		cfg, err := loadConfig()
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("HOST=%q PORT=%q: error = %v, want %q", tc.host, tc.port, err, tc.wantErr)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("HOST=%q PORT=%q: %v", tc.host, tc.port, err)
+			continue
+		}
+		if got := cfg.Addr(); got != tc.want {
+			t.Errorf("HOST=%q PORT=%q: Addr() = %q, want %q", tc.host, tc.port, got, tc.want)
+		}
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.1,2001:db8::/32")
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+	var got []string
+	for _, p := range cfg.TrustedProxies {
+		got = append(got, p.String())
+	}
+	if want := "10.0.0.0/8 192.0.2.1/32 2001:db8::/32"; strings.Join(got, " ") != want {
+		t.Errorf("trusted proxies = %v, want %s", got, want)
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,proxy.internal")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "parsing TRUSTED_PROXIES") {
+		t.Errorf("loading config with a hostname in TRUSTED_PROXIES = %v", err)
+	}
+}
+
+func TestMaintenanceAllow(t *testing.T) {
+	t.Setenv("MAINTENANCE", "true")
+	t.Setenv("MAINTENANCE_ALLOW", "203.0.113.7")
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+	if !cfg.Maintenance || len(cfg.MaintenanceAllow) != 1 || cfg.MaintenanceAllow[0].String() != "203.0.113.7/32" {
+		t.Errorf("maintenance = %t allowing %v", cfg.Maintenance, cfg.MaintenanceAllow)
+	}
+
+	t.Setenv("MAINTENANCE_ALLOW", "me")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "parsing MAINTENANCE_ALLOW") {
+		t.Errorf("loading config with a hostname in MAINTENANCE_ALLOW = %v", err)
+	}
+}
+
+func TestThemeColor(t *testing.T) {
+	for color, ok := range map[string]bool{
+		"#fff":    true,
+		"#1a2B3c": true,
+		"white":   false,
+		"#12345":  false,
+	} {
+		t.Setenv("SITE_THEME_COLOR", color)
+		_, err := loadConfig()
+		if ok && err != nil {
+			t.Errorf("SITE_THEME_COLOR=%q: %v", color, err)
+		} else if !ok && (err == nil || !strings.Contains(err.Error(), "SITE_THEME_COLOR")) {
+			t.Errorf("SITE_THEME_COLOR=%q: error = %v, want one about SITE_THEME_COLOR", color, err)
+		}
+	}
+}
+
+func TestAllowedOrigins(t *testing.T) {
+	t.Setenv("ALLOWED_ORIGINS", "https://reader.example/, http://localhost:3000,*")
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+	if got, want := strings.Join(cfg.AllowedOrigins, " "), "https://reader.example http://localhost:3000 *"; got != want {
+		t.Errorf("allowed origins = %s, want %s", got, want)
+	}
+
+	t.Setenv("ALLOWED_ORIGINS", "https://reader.example/feeds")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "ALLOWED_ORIGINS") {
+		t.Errorf("loading config with a path in ALLOWED_ORIGINS = %v", err)
+	}
+}
+
+func TestAssetPrefixConfig(t *testing.T) {
+	for raw, want := range map[string]string{
+		"":           "",
+		"/":          "",
+		"assets":     "/assets",
+		"/assets/":   "/assets",
+		"/static/v1": "/static/v1",
+	} {
+		t.Setenv("ASSET_PREFIX", raw)
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Errorf("ASSET_PREFIX=%q: %v", raw, err)
+		} else if cfg.AssetPrefix != want {
+			t.Errorf("ASSET_PREFIX=%q: prefix = %q, want %q", raw, cfg.AssetPrefix, want)
+		}
+	}
+	t.Setenv("ASSET_PREFIX", "/assets?v=1")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "ASSET_PREFIX") {
+		t.Errorf("loading config with a query in ASSET_PREFIX = %v", err)
+	}
+}
+
+func TestRequestTimeoutConfig(t *testing.T) {
+	t.Setenv("WRITE_TIMEOUT", "10s")
+	t.Setenv("REQUEST_TIMEOUT", "10s")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "REQUEST_TIMEOUT must be shorter than WRITE_TIMEOUT") {
+		t.Errorf("loading config with REQUEST_TIMEOUT as long as WRITE_TIMEOUT = %v", err)
+	}
+	t.Setenv("REQUEST_TIMEOUT", "9s")
+	if _, err := loadConfig(); err != nil {
+		t.Errorf("loading config: %v", err)
+	}
+}
+
+func TestPostsPerPageConfig(t *testing.T) {
+	t.Setenv("POSTS_PER_PAGE", "25")
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+	if cfg.PostsPerPage != 25 {
+		t.Errorf("posts per page = %d, want 25", cfg.PostsPerPage)
+	}
+	t.Setenv("POSTS_PER_PAGE", "0")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "POSTS_PER_PAGE must be at least 1") {
+		t.Errorf("loading config with POSTS_PER_PAGE=0 = %v", err)
+	}
+}
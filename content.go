@@ -0,0 +1,598 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// siteContent is everything derived from the files under static/, it is
+// built in one go by loadContent and is immutable afterwards.
+type siteContent struct {
+	templates *templateSet
+	// allPosts includes posts scheduled for the future, whereas posts
+	// (and everything derived from it) only has the visible ones.
+	allPosts    []*post
+	nextPublish time.Time
+	// redirectFile is what was read from the redirects file, redirects
+	// also includes the aliases of the visible posts.
+	redirectFile map[string]string
+	redirects    map[string]string
+	// drafts are only set aside in production, where they can be previewed
+	// with a token but aren't listed anywhere, see previewToken. In dev
+	// they're treated like any other post.
+	drafts map[string]*post
+
+	posts     []*post
+	slugIndex map[string]int
+	tagIndex  map[string][]*post
+	tags      []tagCount
+	// series has the posts of each series, in order.
+	series map[string][]*post
+	// featured is the most recent post marked as featured, if any.
+	featured *post
+	related  map[string][]*post
+	archive  []yearGroup
+	stats    siteStats
+	// lastModified is when the most recently changed post was published or
+	// updated, it's sent as Last-Modified for the feeds and sitemap.
+	lastModified time.Time
+	builtAt      time.Time
+
+	rss      *precompressed
+	atom     *precompressed
+	jsonFeed *precompressed
+	sitemap  *precompressed
+	tagFeeds map[string]*precompressed
+}
+
+func loadContent(cfg *Config, fsys fs.FS, assets *assetManifest, logger *slog.Logger) (*siteContent, error) {
+	templates, err := loadTemplates(cfg, fsys, assets)
+	if err != nil {
+		return nil, fmt.Errorf("loading templates: %w", err)
+	}
+
+	posts, err := loadPosts(cfg, fsys, logger)
+	if err != nil {
+		return nil, fmt.Errorf("loading posts: %w", err)
+	}
+	// The public files are only registered at startup, so one added since
+	// can't be used until a restart.
+	for _, p := range posts {
+		if p.Cover != "" && !assets.has(p.Cover) {
+			return nil, fmt.Errorf("cover image %s of %s isn't served, public files are only loaded at startup", p.Cover, p.Slug)
+		}
+	}
+
+	redirects, err := loadRedirects(fsys)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", redirectsFile, err)
+	}
+
+	return buildContent(cfg, templates, posts, redirects, time.Now())
+}
+
+// buildContent derives the indexes, feeds etc. from the posts which are
+// visible at now. In production, posts with a publish date in the future
+// are held back until then, see contentStore.schedule, and drafts are left
+// out entirely.
+func buildContent(cfg *Config, templates *templateSet, allPosts []*post, redirectFile map[string]string, now time.Time) (*siteContent, error) {
+	posts := allPosts
+	var nextPublish time.Time
+	drafts := make(map[string]*post)
+	if production() {
+		posts = nil
+		for _, p := range allPosts {
+			if p.Draft {
+				drafts[p.Slug] = p
+				continue
+			}
+			if p.PublishedAt.After(now) {
+				if nextPublish.IsZero() || p.PublishedAt.Before(nextPublish) {
+					nextPublish = p.PublishedAt
+				}
+				continue
+			}
+			posts = append(posts, p)
+		}
+	}
+
+	var lastModified time.Time
+	slugIndex := make(map[string]int, len(posts))
+	for i, p := range posts {
+		if modified := p.LastModified(); modified.After(lastModified) {
+			lastModified = modified
+		}
+		if _, ok := slugIndex[p.Slug]; ok {
+			return nil, fmt.Errorf("duplicate post %s found, shouldn't be possible", p.Slug)
+		}
+		slugIndex[p.Slug] = i
+	}
+
+	tagIndex := make(map[string][]*post)
+	for _, p := range posts {
+		for _, tag := range p.Tags {
+			tagIndex[tag] = append(tagIndex[tag], p)
+		}
+	}
+
+	redirects, err := buildRedirects(redirectFile, posts)
+	if err != nil {
+		return nil, fmt.Errorf("building redirects: %w", err)
+	}
+
+	feed, err := buildFeed(cfg, cfg.Author+"'s blog", cfg.BaseURL+"/blog", posts)
+	if err != nil {
+		return nil, fmt.Errorf("building feed: %w", err)
+	}
+
+	rss, err := toRSS(feed)
+	if err != nil {
+		return nil, fmt.Errorf("creating rss feed: %w", err)
+	}
+
+	atom, err := feed.ToAtom()
+	if err != nil {
+		return nil, fmt.Errorf("creating atom feed: %w", err)
+	}
+
+	jsonFeed, err := buildJSONFeed(cfg, feed)
+	if err != nil {
+		return nil, fmt.Errorf("creating json feed: %w", err)
+	}
+
+	sitemap, err := buildSitemap(cfg, posts)
+	if err != nil {
+		return nil, fmt.Errorf("creating sitemap: %w", err)
+	}
+
+	// There are only a handful of tags, so their feeds are built up front
+	// rather than on demand.
+	tagFeeds := make(map[string]*precompressed, len(tagIndex))
+	for tag, tagged := range tagIndex {
+		feed, err := buildFeed(cfg, cfg.Author+"'s blog — tag: "+tag, cfg.BaseURL+"/blog/tag/"+url.PathEscape(tag), tagged)
+		if err != nil {
+			return nil, fmt.Errorf("building feed for tag %s: %w", tag, err)
+		}
+		rss, err := toRSS(feed)
+		if err != nil {
+			return nil, fmt.Errorf("creating rss feed for tag %s: %w", tag, err)
+		}
+		if tagFeeds[tag], err = precompress([]byte(rss)); err != nil {
+			return nil, fmt.Errorf("compressing rss feed for tag %s: %w", tag, err)
+		}
+	}
+
+	c := &siteContent{
+		templates:    templates,
+		allPosts:     allPosts,
+		nextPublish:  nextPublish,
+		redirectFile: redirectFile,
+		redirects:    redirects,
+		drafts:       drafts,
+		posts:        posts,
+		slugIndex:    slugIndex,
+		tagIndex:     tagIndex,
+		tags:         tagCloud(tagIndex, cfg.TagOrder),
+		series:       seriesIndex(posts),
+		featured:     featuredPost(posts),
+		related:      relatedPosts(posts, maxRelatedPosts),
+		archive:      groupByYear(posts),
+		stats:        computeStats(posts),
+		lastModified: lastModified,
+		builtAt:      now,
+		tagFeeds:     tagFeeds,
+	}
+	for _, f := range []struct {
+		name string
+		dst  **precompressed
+		body []byte
+	}{
+		{"rss feed", &c.rss, []byte(rss)},
+		{"atom feed", &c.atom, []byte(atom)},
+		{"json feed", &c.jsonFeed, jsonFeed},
+		{"sitemap", &c.sitemap, sitemap},
+	} {
+		if *f.dst, err = precompress(f.body); err != nil {
+			return nil, fmt.Errorf("compressing %s: %w", f.name, err)
+		}
+	}
+	return c, nil
+}
+
+// featuredPost is the most recent of the featured posts, or nil if none of
+// them are. The posts slice is expected to be sorted newest first.
+func featuredPost(posts []*post) *post {
+	for _, p := range posts {
+		if p.Featured {
+			return p
+		}
+	}
+	return nil
+}
+
+// seriesIndex groups the posts which are part of a series, ordered by
+// their SeriesOrder. Orders are unique within a series, see loadPostsFrom.
+func seriesIndex(posts []*post) map[string][]*post {
+	series := make(map[string][]*post)
+	for _, p := range posts {
+		if p.Series != "" {
+			series[p.Series] = append(series[p.Series], p)
+		}
+	}
+	for _, parts := range series {
+		slices.SortFunc(parts, func(a, b *post) int {
+			return cmp.Compare(a.SeriesOrder, b.SeriesOrder)
+		})
+	}
+	return series
+}
+
+// tagCount is a tag along with how many posts have it. Weight is from 1 to
+// tagWeights, depending on how its count compares to the other tags, so the
+// more common tags can be shown larger.
+type tagCount struct {
+	Tag    string
+	Count  int
+	Weight int
+}
+
+const tagWeights = 5
+
+// tagCloud counts the posts with each tag, sorted by tag or, if order is
+// "count", the most common first.
+func tagCloud(tagIndex map[string][]*post, order string) []tagCount {
+	tags := make([]tagCount, 0, len(tagIndex))
+	least, most := 0, 0
+	for tag, tagged := range tagIndex {
+		n := len(tagged)
+		if len(tags) == 0 || n < least {
+			least = n
+		}
+		most = max(most, n)
+		tags = append(tags, tagCount{Tag: tag, Count: n})
+	}
+	for i := range tags {
+		tags[i].Weight = 1
+		if most > least {
+			tags[i].Weight += (tags[i].Count - least) * (tagWeights - 1) / (most - least)
+		}
+	}
+	slices.SortFunc(tags, func(a, b tagCount) int {
+		if order == "count" {
+			return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Tag, b.Tag))
+		}
+		return cmp.Compare(a.Tag, b.Tag)
+	})
+	return tags
+}
+
+type yearGroup struct {
+	Year  int
+	Posts []*post
+}
+
+// groupByYear groups posts by the year they were published in. The posts
+// slice is expected to be sorted newest first, and so are the groups.
+func groupByYear(posts []*post) []yearGroup {
+	var groups []yearGroup
+	for _, p := range posts {
+		year := p.PublishedAt.Year()
+		if len(groups) == 0 || groups[len(groups)-1].Year != year {
+			groups = append(groups, yearGroup{Year: year})
+		}
+		last := &groups[len(groups)-1]
+		last.Posts = append(last.Posts, p)
+	}
+	return groups
+}
+
+// siteStats are shown on the stats page.
+type siteStats struct {
+	Posts        int
+	Words        int
+	AverageWords int
+	First, Last  time.Time
+}
+
+// computeStats totals up posts, which is expected to be sorted newest first.
+func computeStats(posts []*post) siteStats {
+	var stats siteStats
+	if len(posts) == 0 {
+		return stats
+	}
+	for _, p := range posts {
+		stats.Words += p.Words
+	}
+	stats.Posts = len(posts)
+	stats.AverageWords = stats.Words / len(posts)
+	stats.First = posts[len(posts)-1].PublishedAt
+	stats.Last = posts[0].PublishedAt
+	return stats
+}
+
+const maxRelatedPosts = 3
+
+// relatedPosts finds up to n related posts for each post, keyed by slug.
+// Posts are ranked by the number of tags they share, with ties going to the
+// more recent post. Posts without tags are related to the most recent ones.
+// The posts slice is expected to be sorted newest first.
+func relatedPosts(posts []*post, n int) map[string][]*post {
+	related := make(map[string][]*post, len(posts))
+	for _, p := range posts {
+		type candidate struct {
+			post   *post
+			shared int
+		}
+		var candidates []candidate
+		for _, other := range posts {
+			if other == p {
+				continue
+			}
+			shared := 0
+			for _, tag := range other.Tags {
+				if slices.Contains(p.Tags, tag) {
+					shared++
+				}
+			}
+			if shared == 0 && len(p.Tags) > 0 {
+				continue
+			}
+			candidates = append(candidates, candidate{other, shared})
+		}
+		// Stable, so equally ranked posts stay sorted newest first.
+		slices.SortStableFunc(candidates, func(a, b candidate) int {
+			return cmp.Compare(b.shared, a.shared)
+		})
+		for _, c := range candidates[:min(len(candidates), n)] {
+			related[p.Slug] = append(related[p.Slug], c.post)
+		}
+	}
+	return related
+}
+
+// contentStore holds the current siteContent, which is swapped out when
+// the files on disk change in dev, or on a SIGHUP, see Server.reloadOnHangup.
+type contentStore struct {
+	mu      sync.RWMutex
+	current *siteContent
+	// rebuildMu is held while new content is built and swapped in, so that
+	// a reload and publishing scheduled posts can't overwrite each other's
+	// content with what they started from.
+	rebuildMu sync.Mutex
+	// check is run against reloaded content before it's swapped in, see
+	// Server.checkContent.
+	check  func(*siteContent) error
+	cfg    *Config
+	fsys   fs.FS
+	assets *assetManifest
+	logger *slog.Logger
+	// rendered are the templates which a handler renders, it's only written
+	// to while the routes are registered.
+	rendered map[string]bool
+}
+
+func (s *contentStore) get() *siteContent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+func (s *contentStore) set(c *siteContent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current = c
+}
+
+// contentKey is the context key for the content a request is served from,
+// when it's not the current content, see withContent.
+type contentKey struct{}
+
+// withContent serves requests through h from c rather than the current
+// content, so that new content can be checked before it's swapped in.
+func withContent(h http.Handler, c *siteContent) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contentKey{}, c)))
+	})
+}
+
+// forRequest returns the content to serve r from, which is the current
+// content unless r came through withContent.
+func (s *contentStore) forRequest(r *http.Request) *siteContent {
+	if c, ok := r.Context().Value(contentKey{}).(*siteContent); ok {
+		return c
+	}
+	return s.get()
+}
+
+// warnUnusedTemplates logs the templates which were loaded, but which no
+// handler renders, so aren't reachable. It's only a warning, since it's
+// harmless other than being dead code.
+func (s *contentStore) warnUnusedTemplates() {
+	var unused []string
+	for id := range s.get().templates.tmpls {
+		if !s.rendered[id] {
+			unused = append(unused, id)
+		}
+	}
+	if len(unused) > 0 {
+		slices.Sort(unused)
+		s.logger.Warn("found templates which aren't used by any handler", slog.String("templates", strings.Join(unused, ", ")))
+	}
+}
+
+// serveBytes is like the top-level serveBytes, but picks the response out
+// of the current content on each request, rendering the 404 page if there
+// is nothing to pick. Responses carry an ETag and Last-Modified, so polling
+// feed readers get a 304 when nothing has changed. Clients accepting gzip get
+// the copy compressed up front, rather than compress doing it every time.
+func (s *contentStore) serveBytes(contentType string, pick func(*http.Request, *siteContent) *precompressed) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := s.forRequest(r)
+		p := pick(r, c)
+		if p == nil {
+			s.notFound(w, r)
+			return
+		}
+		h := w.Header()
+		h.Set("Content-Type", contentType)
+		h.Set("ETag", p.etag)
+		b := p.raw
+		if negotiateEncoding(r.Header.Get("Accept-Encoding")) == "gzip" {
+			// ServeContent leaves out the length of an encoded body, so
+			// HEAD requests would get a length of 0 without this. It's
+			// replaced for ranges and dropped for a 304.
+			h.Set("Content-Encoding", "gzip")
+			h.Set("Content-Length", strconv.Itoa(len(p.gzip)))
+			b = p.gzip
+		}
+		http.ServeContent(w, r, "", c.lastModified, bytes.NewReader(b))
+	}
+}
+
+// precompressed is a response which never changes once built, along with a
+// gzipped copy and its ETag, so none of them are computed per request.
+type precompressed struct {
+	raw, gzip []byte
+	etag      string
+}
+
+func precompress(b []byte) (*precompressed, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return &precompressed{raw: b, gzip: buf.Bytes(), etag: etag(b)}, nil
+}
+
+// etag derives an entity tag from b. It's weak since compress may encode
+// the same bytes differently, depending on the client.
+func etag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// schedule periodically checks whether a post scheduled for the future is
+// due, and if so rebuilds the content so that it shows up.
+func (s *contentStore) schedule(ctx context.Context, logger *slog.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.publishDue(logger, now)
+		}
+	}
+}
+
+// publishDue rebuilds the content if a scheduled post is due at now.
+func (s *contentStore) publishDue(logger *slog.Logger, now time.Time) {
+	s.rebuildMu.Lock()
+	defer s.rebuildMu.Unlock()
+	c := s.get()
+	if c.nextPublish.IsZero() || now.Before(c.nextPublish) {
+		return
+	}
+	rebuilt, err := buildContent(s.cfg, c.templates, c.allPosts, c.redirectFile, now)
+	if err != nil {
+		logger.Error("failed to publish scheduled posts", slog.String("error", err.Error()))
+		return
+	}
+	s.set(rebuilt)
+	logger.Info("published scheduled posts", slog.Int("posts", len(rebuilt.posts)))
+}
+
+// watch polls the site filesystem for changes and reloads the content when
+// something is modified, added or removed, see reload.
+func (s *contentStore) watch(ctx context.Context, logger *slog.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last, err := fingerprint(s.fsys)
+	if err != nil {
+		logger.Error("failed to fingerprint site files", slog.String("error", err.Error()))
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		fp, err := fingerprint(s.fsys)
+		if err != nil {
+			logger.Error("failed to fingerprint site files", slog.String("error", err.Error()))
+			continue
+		} else if fp == last {
+			continue
+		}
+		last = fp
+		if err := s.reload(logger); err != nil {
+			logger.Error("failed to reload content", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// reload loads the content from scratch, checks it and swaps it in. If that
+// fails the previous content is kept, so a half-written post doesn't break
+// the server.
+func (s *contentStore) reload(logger *slog.Logger) error {
+	s.rebuildMu.Lock()
+	defer s.rebuildMu.Unlock()
+	c, err := loadContent(s.cfg, s.fsys, s.assets, s.logger)
+	if err != nil {
+		return err
+	}
+	if s.check != nil {
+		if err := s.check(c); err != nil {
+			return err
+		}
+	}
+	s.set(c)
+	logger.Info("reloaded content", slog.Int("posts", len(c.posts)))
+	s.warnUnusedTemplates()
+	return nil
+}
+
+// fingerprint summarizes the names, sizes and modification times of all of
+// the files in fsys, so changes can be detected without reading them.
+func fingerprint(fsys fs.FS) (string, error) {
+	var fp []byte
+	if err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fp = fmt.Appendf(fp, "%s:%d:%d;", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	return string(fp), nil
+}
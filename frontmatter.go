@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// frontmatterKeys are the keys postMeta knows about, from its yaml tags.
+var frontmatterKeys = func() []string {
+	var keys []string
+	t := reflect.TypeOf(postMeta{})
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name != "" && name != "-" {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}()
+
+// unknownFrontmatterKeys returns the keys of raw which postMeta doesn't
+// have a field for, which would otherwise be silently ignored, sorted.
+func unknownFrontmatterKeys(raw map[string]any) []string {
+	var unknown []string
+	for key := range raw {
+		if !slices.Contains(frontmatterKeys, key) {
+			unknown = append(unknown, key)
+		}
+	}
+	slices.Sort(unknown)
+	return unknown
+}
+
+// describeUnknownKeys lists unknown frontmatter keys for an error or log
+// message, suggesting the known key each one is most likely a typo of.
+func describeUnknownKeys(unknown []string) string {
+	described := make([]string, len(unknown))
+	for i, key := range unknown {
+		described[i] = key
+		if suggestion := closestKey(key); suggestion != "" {
+			described[i] += fmt.Sprintf(" (did you mean %s?)", suggestion)
+		}
+	}
+	return strings.Join(described, ", ")
+}
+
+// closestKey finds the known frontmatter key within two edits of key, or
+// an empty string if there's none.
+func closestKey(key string) string {
+	best, bestDistance := "", 3
+	for _, known := range frontmatterKeys {
+		if d := editDistance(key, known); d < bestDistance {
+			best, bestDistance = known, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := range len(a) {
+		cur := make([]int, len(b)+1)
+		cur[0] = i + 1
+		for j := range len(b) {
+			cost := 1
+			if a[i] == b[j] {
+				cost = 0
+			}
+			cur[j+1] = min(prev[j+1]+1, cur[j]+1, prev[j]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
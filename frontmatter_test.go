@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFrontmatterValidation(t *testing.T) {
+	fsys := fstest.MapFS{
+		"posts/typo.md": &fstest.MapFile{Data: []byte(`---
+title: "Typo"
+published: "Jan 02 2023 PST"
+tgas: [go]
+colour: blue
+---
+
+Hello.
+`)},
+		"posts/untitled.md": &fstest.MapFile{Data: []byte(`---
+published: "Jan 02 2023 PST"
+---
+
+Hello.
+`)},
+		"posts/bare.md": &fstest.MapFile{Data: []byte("Hello.\n")},
+	}
+
+	p, err := loadPost(testConfig, fsys, "posts/typo.md")
+	if err != nil {
+		t.Fatalf("loading post with unknown keys: %v", err)
+	}
+	if got := describeUnknownKeys(p.unknownKeys); got != "colour, tgas (did you mean tags?)" {
+		t.Errorf("unknown keys = %q", got)
+	}
+
+	strict := *testConfig
+	strict.StrictFrontmatter = true
+	if _, err := loadPost(&strict, fsys, "posts/typo.md"); err == nil || !strings.Contains(err.Error(), "tgas (did you mean tags?)") {
+		t.Errorf("loading post with unknown keys in strict mode = %v", err)
+	}
+
+	for path, want := range map[string]string{
+		"posts/untitled.md": "frontmatter is missing title",
+		"posts/bare.md":     "frontmatter is missing title and published",
+	} {
+		if _, err := loadPost(testConfig, fsys, path); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("loading %s = %v, want %q", path, err, want)
+		}
+	}
+
+	// The typo'd post loads fine, so leave only the broken one.
+	broken := fstest.MapFS{"posts/untitled.md": fsys["posts/untitled.md"]}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if _, err := loadPostsFrom(testConfig, broken, "posts", 1, logger); err == nil || !strings.Contains(err.Error(), "loading untitled.md") {
+		t.Errorf("loading posts = %v, want an error naming the file", err)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"title", "title", 0},
+		{"titel", "title", 2},
+		{"tag", "tags", 1},
+		{"summary", "", 7},
+	} {
+		if got := editDistance(tc.a, tc.b); got != tc.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
@@ -3,17 +3,22 @@ module morgangallant.com
 go 1.22.0
 
 require (
+	github.com/alecthomas/chroma/v2 v2.2.0
 	github.com/gorilla/feeds v1.1.2
 	github.com/joho/godotenv v1.5.1
 	github.com/microcosm-cc/bluemonday v1.0.26
+	github.com/tdewolff/minify/v2 v2.20.37
 	github.com/yuin/goldmark v1.5.4
+	github.com/yuin/goldmark-highlighting/v2 v2.0.0-20230729083705-37449abec8cc
 	go.abhg.dev/goldmark/frontmatter v0.1.0
+	golang.org/x/net v0.17.0
 )
 
 require (
 	github.com/BurntSushi/toml v1.2.1 // indirect
 	github.com/aymerick/douceur v0.2.0 // indirect
+	github.com/dlclark/regexp2 v1.7.0 // indirect
 	github.com/gorilla/css v1.0.0 // indirect
-	golang.org/x/net v0.17.0 // indirect
+	github.com/tdewolff/parse/v2 v2.7.15 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
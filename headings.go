@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// headingIDs generates slugified heading ids which are unique within a post,
+// repeated headings get -2, -3 etc. appended. It replaces goldmark's default
+// generator, which starts counting from -1.
+type headingIDs map[string]bool
+
+func (ids headingIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	base := slugify(value)
+	if base == "" {
+		base = "section"
+	}
+	id := base
+	for i := 2; ids[id]; i++ {
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+	ids[id] = true
+	return []byte(id)
+}
+
+func (ids headingIDs) Put(value []byte) {
+	ids[string(value)] = true
+}
+
+// slugify lowercases the letters and digits in value, and joins runs of
+// them with single hyphens.
+func slugify(value []byte) string {
+	var slug []rune
+	hyphen := false
+	for len(value) > 0 {
+		r, size := utf8.DecodeRune(value)
+		value = value[size:]
+		if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			if hyphen && len(slug) > 0 {
+				slug = append(slug, '-')
+			}
+			hyphen = false
+			slug = append(slug, unicode.ToLower(r))
+		} else if r != '\'' {
+			hyphen = true
+		}
+	}
+	return string(slug)
+}
+
+// anchorLevelKey holds the deepest heading level which headingAnchors adds
+// a link to in the parser context, see Config.AnchorLevel.
+var anchorLevelKey = parser.NewContextKey()
+
+// headingAnchors appends a link to itself to every heading from h2 down to
+// the anchor level, so readers can link to a section. The link has no text
+// so it doesn't end up in the TOC or search index, the "#" is added in
+// styles.css. Ids come from the heading's text, see headingIDs, so links
+// keep working when other parts of the post are edited.
+type headingAnchors struct{}
+
+func (headingAnchors) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	level, _ := pc.Get(anchorLevelKey).(int)
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		h, ok := n.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		if h.Level < 2 || h.Level > level {
+			return ast.WalkSkipChildren, nil
+		}
+		id, ok := h.AttributeString("id")
+		if !ok {
+			return ast.WalkSkipChildren, nil
+		}
+		idBytes, _ := id.([]byte)
+		link := ast.NewLink()
+		link.Destination = append([]byte("#"), idBytes...)
+		link.SetAttributeString("class", []byte("heading-anchor"))
+		link.SetAttributeString("title", []byte("Link to this section"))
+		h.AppendChild(h, link)
+		return ast.WalkSkipChildren, nil
+	})
+}
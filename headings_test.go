@@ -0,0 +1,104 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestHeadingAnchors(t *testing.T) {
+	fsys := fstest.MapFS{"posts/headings.md": &fstest.MapFile{Data: []byte(`---
+title: "Headings"
+published: "Feb 18 2023 PST"
+---
+
+## Getting Started
+
+### What's ` + "`go`" + ` -- really?
+
+## Getting Started
+
+## Getting Started
+
+#### Too deep
+`)}}
+	p, err := loadPost(testConfig, fsys, "posts/headings.md")
+	if err != nil {
+		t.Fatalf("loading post: %v", err)
+	}
+	content := string(p.Content)
+	for _, want := range []string{
+		`<h2 id="getting-started">Getting Started<a href="#getting-started" class="heading-anchor" title="Link to this section"`,
+		`<h3 id="whats-go-really">What&#39;s <code>go</code> -- really?<a href="#whats-go-really"`,
+		`<h2 id="getting-started-2">`,
+		`<h2 id="getting-started-3">`,
+		`<h4 id="too-deep">Too deep</h4>`,
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("rendered post is missing %q, got:\n%s", want, content)
+		}
+	}
+
+	var ids []string
+	for _, h := range p.TOC {
+		ids = append(ids, h.ID)
+	}
+	if want := []string{"getting-started", "whats-go-really", "getting-started-2", "getting-started-3"}; !slices.Equal(ids, want) {
+		t.Errorf("toc ids = %v, want %v", ids, want)
+	}
+	if p.TOC[0].Text != "Getting Started" {
+		t.Errorf("toc text = %q, want %q", p.TOC[0].Text, "Getting Started")
+	}
+}
+
+func TestHeadingAnchorLevel(t *testing.T) {
+	source := []byte("## Setup\n\n### Install\n\n#### On Linux\n\n##### Notes\n")
+	for level, want := range map[int][]string{
+		2: {`<h2 id="setup">Setup<a href="#setup"`, `<h3 id="install">Install</h3>`},
+		4: {`<h3 id="install">Install<a href="#install"`, `<h4 id="on-linux">On Linux<a href="#on-linux"`, `<h5 id="notes">Notes</h5>`},
+	} {
+		rendered, meta, err := renderMarkdown(source, "morgangallant.com", level)
+		if err != nil {
+			t.Fatalf("rendering with anchor level %d: %v", level, err)
+		}
+		if got := meta.TOC[len(meta.TOC)-1].Level; len(meta.TOC) != level-1 || got != level {
+			t.Errorf("anchor level %d: toc has %d headings down to h%d", level, len(meta.TOC), got)
+		}
+		for _, w := range want {
+			if !strings.Contains(string(rendered), w) {
+				t.Errorf("anchor level %d: rendered post is missing %q, got:\n%s", level, w, rendered)
+			}
+		}
+	}
+}
+
+// Heading ids are shared as links, so they must only depend on the heading
+// itself, rather than on where it is in the post.
+func TestHeadingIDsAreStable(t *testing.T) {
+	ids := func(source string) []string {
+		t.Helper()
+		rendered, _, err := renderMarkdown([]byte(source), "morgangallant.com", 4)
+		if err != nil {
+			t.Fatalf("rendering: %v", err)
+		}
+		var ids []string
+		for _, part := range strings.Split(string(rendered), ` id="`)[1:] {
+			id, _, _ := strings.Cut(part, `"`)
+			ids = append(ids, id)
+		}
+		return ids
+	}
+	const post = "## Why\n\nBecause.\n\n### The details\n\n#### Edge cases\n"
+	first, again := ids(post), ids(post)
+	if !slices.Equal(first, again) {
+		t.Errorf("ids changed between renders: %v and %v", first, again)
+	}
+	if want := []string{"why", "the-details", "edge-cases"}; !slices.Equal(first, want) {
+		t.Errorf("ids = %v, want %v", first, want)
+	}
+	edited := ids("Some new intro.\n\n## Background\n\nMore words.\n\n" + post)
+	if want := []string{"background", "why", "the-details", "edge-cases"}; !slices.Equal(edited, want) {
+		t.Errorf("ids after adding a section = %v, want %v", edited, want)
+	}
+}
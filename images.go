@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// imageExtension lazy loads the images in posts, so they don't hold up the
+// rest of the page. Images which are in a paragraph of their own are also
+// wrapped in a <figure>, with their alt text as the caption.
+type imageExtension struct{}
+
+func (imageExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(util.Prioritized(imageTransformer{}, 100)))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(figureRenderer{}, 500)))
+}
+
+var kindFigure = ast.NewNodeKind("Figure")
+
+// figure wraps a single image, see imageExtension.
+type figure struct {
+	ast.BaseBlock
+}
+
+func (n *figure) Kind() ast.NodeKind { return kindFigure }
+
+func (n *figure) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+type imageTransformer struct{}
+
+func (imageTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	var standalone []*ast.Paragraph
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		img, ok := n.(*ast.Image)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		// Attributes set explicitly are left as they are.
+		if _, ok := img.AttributeString("loading"); !ok {
+			img.SetAttributeString("loading", []byte("lazy"))
+		}
+		if _, ok := img.AttributeString("decoding"); !ok {
+			img.SetAttributeString("decoding", []byte("async"))
+		}
+		if p, ok := img.Parent().(*ast.Paragraph); ok && p.ChildCount() == 1 && len(img.Text(reader.Source())) > 0 {
+			standalone = append(standalone, p)
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	// The paragraphs are replaced after walking, so the walk isn't disturbed.
+	for _, p := range standalone {
+		fig := &figure{}
+		img := p.FirstChild()
+		p.Parent().ReplaceChild(p.Parent(), p, fig)
+		fig.AppendChild(fig, img)
+	}
+}
+
+type figureRenderer struct{}
+
+func (r figureRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindFigure, r.renderFigure)
+}
+
+func (figureRenderer) renderFigure(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		_, _ = w.WriteString("<figure>")
+		return ast.WalkContinue, nil
+	}
+	_, _ = w.WriteString("<figcaption>")
+	_, _ = w.Write(util.EscapeHTML(n.FirstChild().Text(source)))
+	_, _ = w.WriteString("</figcaption></figure>\n")
+	return ast.WalkContinue, nil
+}
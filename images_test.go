@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestImages(t *testing.T) {
+	fsys := fstest.MapFS{"posts/images.md": &fstest.MapFile{Data: []byte(`---
+title: "Images"
+published: "Feb 18 2023 PST"
+---
+
+![A gopher, waving](/gopher.png)
+
+![Fish & chips](/lunch.png)
+
+Some text with an inline ![icon](/icon.png) image.
+
+![](/no-alt.png)
+`)}}
+	p, err := loadPost(testConfig, fsys, "posts/images.md")
+	if err != nil {
+		t.Fatalf("loading post: %v", err)
+	}
+	content := string(p.Content)
+	for _, want := range []string{
+		`<figure><img src="/gopher.png" alt="A gopher, waving" loading="lazy" decoding="async"><figcaption>A gopher, waving</figcaption></figure>`,
+		`<figcaption>Fish &amp; chips</figcaption>`,
+		`<p>Some text with an inline <img src="/icon.png" alt="icon" loading="lazy" decoding="async"> image.</p>`,
+		`<p><img src="/no-alt.png" alt="" loading="lazy" decoding="async"></p>`,
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("rendered post is missing %q, got:\n%s", want, content)
+		}
+	}
+	if want := "Some text with an inline image."; p.Summary != want {
+		t.Errorf("summary = %q, want %q", p.Summary, want)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"golang.org/x/net/html"
+)
+
+// siteHostKey holds the host of the site in the parser context, so links
+// to it can be told apart from external ones.
+var siteHostKey = parser.NewContextKey()
+
+// externalLinks makes links to other sites open in a new tab, without
+// giving the new page access to this one via window.opener or the referrer.
+// Relative links and absolute links to the site itself are left alone.
+type externalLinks struct{}
+
+func (externalLinks) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	host, _ := pc.Get(siteHostKey).(string)
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		var dest []byte
+		switch n := n.(type) {
+		case *ast.Link:
+			dest = n.Destination
+		case *ast.AutoLink:
+			if n.AutoLinkType != ast.AutoLinkURL {
+				return ast.WalkContinue, nil
+			}
+			dest = n.URL(reader.Source())
+		default:
+			return ast.WalkContinue, nil
+		}
+		u, err := url.Parse(string(dest))
+		if err != nil || u.Host == "" || strings.EqualFold(u.Hostname(), host) {
+			return ast.WalkContinue, nil
+		}
+		n.SetAttributeString("target", []byte("_blank"))
+		n.SetAttributeString("rel", []byte("noopener noreferrer"))
+		return ast.WalkContinue, nil
+	})
+}
+
+// checkLinks finds links and images in posts which point at pages or files
+// on the site which don't exist, by requesting each of them from h. Anything
+// which responds with less than a 400 counts as existing.
+func checkLinks(cfg *Config, h http.Handler, posts []*post) error {
+	site, err := url.Parse(cfg.BaseURL)
+	if err != nil {
+		return fmt.Errorf("parsing base url: %w", err)
+	}
+	resolves := make(map[string]bool)
+	var errs []error
+	for _, p := range posts {
+		base := site.JoinPath("blog", p.Slug)
+		refs, err := linkTargets(string(p.Content))
+		if err != nil {
+			return fmt.Errorf("finding links in %s: %w", p.Slug, err)
+		}
+		for _, ref := range refs {
+			if strings.HasPrefix(ref, "#") {
+				continue
+			}
+			u, err := url.Parse(ref)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s links to %s: %w", p.Slug, ref, err))
+				continue
+			}
+			u = base.ResolveReference(u)
+			if (u.Scheme != "http" && u.Scheme != "https") || !strings.EqualFold(u.Host, site.Host) {
+				continue
+			}
+			target := u.RequestURI()
+			ok, checked := resolves[target]
+			if !checked {
+				ok = status(h, target) < http.StatusBadRequest
+				resolves[target] = ok
+			}
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s links to %s, which doesn't exist", p.Slug, ref))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// linkTargets returns the href and src attributes in content.
+func linkTargets(content string) ([]string, error) {
+	var refs []string
+	z := html.NewTokenizer(strings.NewReader(content))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if err := z.Err(); !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			return refs, nil
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		for _, attr := range z.Token().Attr {
+			if attr.Key == "href" || attr.Key == "src" {
+				refs = append(refs, attr.Val)
+			}
+		}
+	}
+}
+
+// status is the status code h responds to a GET of path with.
+func status(h http.Handler, path string) int {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code
+}
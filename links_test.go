@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestExternalLinks(t *testing.T) {
+	fsys := fstest.MapFS{"posts/links.md": &fstest.MapFile{Data: []byte(`---
+title: "Links"
+published: "Feb 18 2023 PST"
+---
+
+An [external](https://go.dev/doc) link, a [relative](/blog/other) one, an
+[absolute internal](https://morgangallant.com/uses) one, an autolinked
+https://example.com/page and a [section](#intro) link.
+`)}}
+	p, err := loadPost(testConfig, fsys, "posts/links.md")
+	if err != nil {
+		t.Fatalf("loading post: %v", err)
+	}
+	content := string(p.Content)
+	for _, want := range []string{
+		`<a href="https://go.dev/doc" target="_blank" rel="noopener noreferrer nofollow">external</a>`,
+		`<a href="/blog/other" rel="nofollow">relative</a>`,
+		`<a href="https://morgangallant.com/uses" rel="nofollow">absolute internal</a>`,
+		`<a href="https://example.com/page" target="_blank" rel="noopener noreferrer nofollow">https://example.com/page</a>`,
+		`<a href="#intro" rel="nofollow">section</a>`,
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("rendered post is missing %q, got:\n%s", want, content)
+		}
+	}
+}
+
+func TestCheckLinks(t *testing.T) {
+	srv := newTestServer(t, testConfig, testFS())
+	p := &post{Slug: "links", Content: `<p>
+<a href="/blog/first-post">post</a> <a href="second-post#section">relative</a>
+<a href="/blog/">slash</a> <a href="/posts">redirected</a> <a href="#top">fragment</a>
+<a href="https://morgangallant.com/uses">absolute</a> <a href="https://go.dev/nope">external</a>
+<a href="mailto:morgan@morgangallant.com">mail</a> <img src="/styles.css">
+<a href="/blog/missing">missing</a> <img src="../missing.png">
+</p>`}
+	err := checkLinks(testConfig, trimTrailingSlash(srv.mux), []*post{p})
+	if err == nil {
+		t.Fatal("expected the broken links to be found")
+	}
+	got := strings.Split(err.Error(), "\n")
+	want := []string{
+		"links links to /blog/missing, which doesn't exist",
+		"links links to ../missing.png, which doesn't exist",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("checkLinks found:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestStrictLinks(t *testing.T) {
+	fsys := testFS()
+	fsys["posts/broken.md"] = &fstest.MapFile{Data: []byte(`---
+title: "Broken"
+published: "Mar 04 2023 PST"
+---
+
+A link to a [missing](/blog/missing) post.
+`)}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if _, err := newServer(testConfig, fsys, logger); err != nil {
+		t.Errorf("broken links should only be logged by default, got %v", err)
+	}
+	cfg := *testConfig
+	cfg.StrictLinks = true
+	if _, err := newServer(&cfg, fsys, logger); err == nil || !strings.Contains(err.Error(), "broken links to /blog/missing") {
+		t.Errorf("newServer with StrictLinks = %v, want an error about /blog/missing", err)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// setupLogger creates the logger described by cfg. The returned func closes
+// the log file, if there is one, and should be called before exiting.
+func setupLogger(cfg *Config) (*slog.Logger, func() error, error) {
+	var out io.Writer = os.Stdout
+	closeLog := func() error { return nil }
+	if cfg.LogFile != "" {
+		f, err := os.OpenFile(cfg.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening log file: %w", err)
+		}
+		out = io.MultiWriter(os.Stdout, f)
+		closeLog = f.Close
+	}
+
+	level := cfg.LogLevel
+	if !production() {
+		level = slog.LevelDebug
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if cfg.LogFormat == "json" {
+		handler = slog.NewJSONHandler(out, opts)
+	} else {
+		handler = slog.NewTextHandler(out, opts)
+	}
+	return slog.New(handler), closeLog, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetupLogger(t *testing.T) {
+	for _, format := range []string{"json", "text"} {
+		path := filepath.Join(t.TempDir(), "site.log")
+		logger, closeLog, err := setupLogger(&Config{LogLevel: slog.LevelWarn, LogFormat: format, LogFile: path})
+		if err != nil {
+			t.Fatalf("setting up %s logger: %v", format, err)
+		}
+		logger.Info("ignored")
+		logger.Warn("hello", slog.String("key", "value"))
+		if err := closeLog(); err != nil {
+			t.Fatalf("closing log: %v", err)
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("reading log file: %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		if len(lines) != 1 {
+			t.Fatalf("%s log has %d lines, want 1:\n%s", format, len(lines), content)
+		}
+		var line map[string]any
+		isJSON := json.Unmarshal([]byte(lines[0]), &line) == nil
+		if isJSON != (format == "json") || !strings.Contains(lines[0], "hello") || !strings.Contains(lines[0], "value") {
+			t.Errorf("unexpected %s log line %s", format, lines[0])
+		}
+	}
+}
+
+func TestLogFormat(t *testing.T) {
+	t.Setenv("LOG_FORMAT", "xml")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "LOG_FORMAT") {
+		t.Errorf("loading config with LOG_FORMAT=xml = %v", err)
+	}
+}
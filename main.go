@@ -5,6 +5,8 @@ import (
 	"cmp"
 	"context"
 	"embed"
+	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"html/template"
@@ -12,9 +14,11 @@ import (
 	"io/fs"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"slices"
 	"strconv"
@@ -22,21 +26,24 @@ import (
 	"sync"
 	"time"
 
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/styles"
 	"github.com/gorilla/feeds"
 	"github.com/joho/godotenv"
 	"github.com/microcosm-cc/bluemonday"
+	"github.com/tdewolff/minify/v2"
+	minhtml "github.com/tdewolff/minify/v2/html"
 	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
 	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
 	"go.abhg.dev/goldmark/frontmatter"
+	"golang.org/x/net/html"
 )
 
-var logLevels = map[string]slog.Level{
-	"DEBUG": slog.LevelDebug,
-	"INFO":  slog.LevelInfo,
-	"WARN":  slog.LevelWarn,
-	"ERROR": slog.LevelError,
-}
-
 var (
 	production_once   sync.Once
 	production_cached bool
@@ -57,28 +64,28 @@ func production() bool {
 func main() {
 	_ = godotenv.Load()
 
-	var logLevel slog.Level
-	if level, ok := logLevels[os.Getenv("LOG_LEVEL")]; ok {
-		logLevel = level
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("loading config", slog.String("error", err.Error()))
+		os.Exit(1)
 	}
 
-	var logger *slog.Logger
-	if production() {
-		logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-			Level: logLevel,
-		}))
-	} else {
-		logLevel = slog.LevelDebug
-		logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-			Level: logLevel,
-		}))
+	logger, closeLog, err := setupLogger(cfg)
+	if err != nil {
+		slog.Error("setting up logging", slog.String("error", err.Error()))
+		os.Exit(1)
 	}
 
 	rctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
-	defer cancel()
-
-	if err := run(rctx, logger, cancel); err != nil {
+	err = run(rctx, cfg, logger, cancel)
+	cancel()
+	if err != nil {
 		logger.Error("encountered top-level error", slog.String("error", err.Error()))
+	}
+	if err := closeLog(); err != nil {
+		slog.Error("closing log file", slog.String("error", err.Error()))
+	}
+	if err != nil {
 		os.Exit(1)
 	}
 }
@@ -86,185 +93,584 @@ func main() {
 //go:embed static
 var staticFiles embed.FS
 
-func run(ctx context.Context, logger *slog.Logger, shutdown context.CancelFunc) error {
-	templates, err := loadTemplates()
-	if err != nil {
-		return fmt.Errorf("loading templates: %w", err)
+// siteFS returns the filesystem the site content is read from. Production
+// uses the embedded copy unless CONTENT_DIR is set, whereas in dev the files
+// are read from disk (when running from the repository root) so that edits
+// can be picked up without a restart. Everything else takes the filesystem
+// as an argument, so tests can pass in an fstest.MapFS instead.
+func siteFS(cfg *Config) (fs.FS, error) {
+	if cfg.ContentDir != "" {
+		if info, err := os.Stat(cfg.ContentDir); err != nil {
+			return nil, fmt.Errorf("checking CONTENT_DIR: %w", err)
+		} else if !info.IsDir() {
+			return nil, fmt.Errorf("CONTENT_DIR %s isn't a directory", cfg.ContentDir)
+		}
+		return os.DirFS(cfg.ContentDir), nil
+	}
+	if info, err := os.Stat("static"); err == nil && info.IsDir() && !production() {
+		return os.DirFS("static"), nil
 	}
+	return fs.Sub(staticFiles, "static")
+}
 
-	posts, err := loadPosts()
+func run(ctx context.Context, cfg *Config, logger *slog.Logger, shutdown context.CancelFunc) error {
+	fsys, err := siteFS(cfg)
 	if err != nil {
-		return fmt.Errorf("loading posts: %w", err)
+		return fmt.Errorf("opening site files: %w", err)
 	}
 
-	slugIndex := make(map[string]int, len(posts))
-	for i, p := range posts {
-		if _, ok := slugIndex[p.Slug]; ok {
-			return fmt.Errorf("duplicate post %s found, shouldn't be possible", p.Slug)
-		}
-		slugIndex[p.Slug] = i
+	srv, err := newServer(cfg, fsys, logger)
+	if err != nil {
+		return err
 	}
+	go srv.keepFresh(ctx)
+	go srv.reloadOnHangup(ctx)
 
-	mux := http.NewServeMux()
-
-	if err := registerPublicDir(mux); err != nil {
-		return fmt.Errorf("registering public files with mux: %w", err)
+	httpAddr := cfg.Addr()
+	httpSrv := &http.Server{
+		Addr:              httpAddr,
+		Handler:           srv.Handler(),
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
 	}
-
-	if err := templates.registerHandler(mux, "GET /", "index", func(_ *http.Request) (any, error) {
-		type innerType struct {
-			RecentPosts []*post
+	go func() {
+		if err := httpSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("http server returned, shutting down", slog.String("error", err.Error()))
+			shutdown()
+			return
 		}
-		return templateData[innerType]{
-			Inner: innerType{
-				RecentPosts: posts[:min(len(posts), 3)],
-			},
-		}, nil
-	}); err != nil {
-		return fmt.Errorf("register index handler: %w", err)
-	}
+		logger.Info("http server shutdown")
+	}()
+	defer func() {
+		srv.drain()
+		sctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := httpSrv.Shutdown(sctx); err != nil {
+			logger.Error("failed to shutdown http server", slog.String("error", err.Error()))
+		}
+	}()
+	logger.Info("started http server", slog.String("addr", httpAddr), slog.Any("build", srv.build))
 
-	if err := templates.registerHandler(mux, "GET /blog", "blog", func(_ *http.Request) (any, error) {
-		type innerType struct {
-			Posts []*post
+	if cfg.MetricsAddr != "" {
+		adminSrv := &http.Server{
+			Addr:              cfg.MetricsAddr,
+			Handler:           srv.metrics,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
 		}
-		return templateData[innerType]{
-			Inner: innerType{
-				Posts: posts,
-			},
-			Subtitle: "Blog",
-		}, nil
-	}); err != nil {
-		return fmt.Errorf("registering blog handler: %w", err)
+		go func() {
+			if err := adminSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("metrics server returned, shutting down", slog.String("error", err.Error()))
+				shutdown()
+			}
+		}()
+		defer func() {
+			if err := adminSrv.Close(); err != nil {
+				logger.Error("failed to close metrics server", slog.String("error", err.Error()))
+			}
+		}()
+		logger.Info("started metrics server", slog.String("addr", cfg.MetricsAddr))
 	}
 
-	if err := templates.registerHandler(mux, "GET /blog/{slug}", "blog_post", func(r *http.Request) (any, error) {
-		idx, ok := slugIndex[r.PathValue("slug")]
-		if !ok {
-			return nil, errNotFound
+	<-ctx.Done()
+	return nil
+}
+
+// serveBytes returns a handler which writes out a precomputed response.
+func serveBytes(contentType string, b []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		if n, err := w.Write(b); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if n != len(b) {
+			http.Error(w, "short write", http.StatusInternalServerError)
+			return
 		}
-		p := posts[idx]
-		return templateData[*post]{
-			Inner:    p,
-			Subtitle: p.Title,
-		}, nil
-	}); err != nil {
-		return fmt.Errorf("registering blog post handler: %w", err)
 	}
+}
 
-	if err := templates.registerHandler(mux, "GET /uses", "uses", func(_ *http.Request) (any, error) {
-		type innerType struct{}
-		return templateData[innerType]{
-			Subtitle: "Uses",
-		}, nil
-	}); err != nil {
-		return fmt.Errorf("registering uses page: %w", err)
+// buildFeed creates the feed shared by all of the feed formats (rss, atom,
+// json) from posts. The per-tag feeds are built the same way, with their
+// own title and link.
+func buildFeed(cfg *Config, title, link string, posts []*post) (*feeds.Feed, error) {
+	site, err := url.Parse(cfg.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing base url: %w", err)
 	}
-
+	author := &feeds.Author{Name: cfg.Author, Email: cfg.AuthorEmail}
 	feed := &feeds.Feed{
-		Title:       "Morgan Gallant's blog",
-		Link:        &feeds.Link{Href: "https://morgangallant.com/blog"},
-		Description: "Ramblings about technology, software... and probably some other stuff too",
-		Author:      &feeds.Author{Name: "Morgan Gallant", Email: "morgan@morgangallant.com"},
+		Title:       title,
+		Link:        &feeds.Link{Href: link},
+		Description: cfg.Description,
+		Author:      author,
 		Created:     time.Now(),
 	}
 	for _, p := range posts {
+		link := cfg.BaseURL + "/blog/" + p.Slug
+		content, err := absolutizeURLs(string(p.Content), link)
+		if err != nil {
+			return nil, fmt.Errorf("rewriting urls for %s: %w", p.Slug, err)
+		}
 		feed.Items = append(feed.Items, &feeds.Item{
-			Title:   p.Title,
-			Link:    &feeds.Link{Href: "https://morgangallant.com/blog/" + p.Slug},
-			Author:  &feeds.Author{Name: "Morgan Gallant", Email: "morgan@morgangallant.com"},
-			Created: p.PublishedAt,
+			Id:          itemID(site.Hostname(), p),
+			Title:       p.Title,
+			Link:        &feeds.Link{Href: link},
+			Author:      &feeds.Author{Name: p.Author, Email: p.AuthorEmail},
+			Created:     p.PublishedAt,
+			Updated:     p.UpdatedAt,
+			Description: p.Summary,
+			Content:     content,
 		})
+		if modified := p.LastModified(); modified.After(feed.Updated) {
+			feed.Updated = modified
+		}
 	}
-	rss, err := feed.ToRss()
-	if err != nil {
-		return fmt.Errorf("creating rss feed: %w", err)
+	return feed, nil
+}
+
+// itemID is the id of a post in feeds, a tag uri (see RFC 4151) made up of
+// the host of the site, the day the post was published and its slug. None
+// of these change when the post is edited, so readers don't show it again.
+func itemID(host string, p *post) string {
+	return fmt.Sprintf("tag:%s,%s:/blog/%s", host, p.PublishedAt.Format(time.DateOnly), p.Slug)
+}
+
+// rssFeed marshals a feed to rss the same way feeds.Rss does, except that
+// the guids of items are marked as not being permalinks, since they're tag
+// uris rather than urls, see itemID. Without isPermaLink, readers assume
+// that a guid is one.
+type rssFeed struct {
+	*feeds.Feed
+}
+
+type rssDocument struct {
+	XMLName          xml.Name `xml:"rss"`
+	Version          string   `xml:"version,attr"`
+	ContentNamespace string   `xml:"xmlns:content,attr"`
+	Channel          rssChannel
+}
+
+// rssChannel and rssItem shadow the fields of the feeds types they embed
+// which need to be marshaled differently.
+type rssChannel struct {
+	*feeds.RssFeed
+	Items []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	*feeds.RssItem
+	Guid rssGUID `xml:"guid"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	ID          string `xml:",chardata"`
+}
+
+// toRSS marshals feed to rss, see rssFeed.
+func toRSS(feed *feeds.Feed) (string, error) {
+	return feeds.ToXML(rssFeed{feed})
+}
+
+func (f rssFeed) FeedXml() any {
+	x := (&feeds.Rss{Feed: f.Feed}).FeedXml().(*feeds.RssFeedXml)
+	doc := &rssDocument{
+		Version:          x.Version,
+		ContentNamespace: x.ContentNamespace,
+		Channel:          rssChannel{RssFeed: x.Channel},
+	}
+	for _, item := range x.Channel.Items {
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{RssItem: item, Guid: rssGUID{ID: item.Guid}})
 	}
+	return doc
+}
 
-	mux.HandleFunc("GET /feed.xml", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/rss+xml")
-		if n, err := w.Write([]byte(rss)); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		} else if n != len(rss) {
-			http.Error(w, "short write", http.StatusInternalServerError)
-			return
+// absolutizeURLs rewrites relative links and image sources in a rendered
+// post so that they still resolve when the html is read outside of the site,
+// i.e. in a feed reader.
+func absolutizeURLs(content, postURL string) (string, error) {
+	base, err := url.Parse(postURL)
+	if err != nil {
+		return "", fmt.Errorf("parsing post url: %w", err)
+	}
+	var buf strings.Builder
+	z := html.NewTokenizer(strings.NewReader(content))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if err := z.Err(); !errors.Is(err, io.EOF) {
+				return "", err
+			}
+			return buf.String(), nil
 		}
-	})
-
-	var port uint16 = 8080
-	if portStr, ok := os.LookupEnv("PORT"); ok {
-		parsed, err := strconv.ParseUint(portStr, 10, 16)
-		if err != nil {
-			return fmt.Errorf("parsing port string %s: %w", portStr, err)
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			buf.Write(z.Raw())
+			continue
+		}
+		tok := z.Token()
+		for i, attr := range tok.Attr {
+			if attr.Key != "href" && attr.Key != "src" {
+				continue
+			}
+			ref, err := url.Parse(attr.Val)
+			if err != nil || ref.IsAbs() {
+				continue
+			}
+			tok.Attr[i].Val = base.ResolveReference(ref).String()
 		}
-		port = uint16(parsed)
+		buf.WriteString(tok.String())
 	}
+}
 
-	httpAddr := fmt.Sprintf("0.0.0.0:%d", port)
-	httpSrv := &http.Server{
-		Addr:         httpAddr,
-		Handler:      mux,
-		ReadTimeout:  time.Second,
-		WriteTimeout: time.Second * 10,
+type jsonFeedItem struct {
+	ID            string     `json:"id"`
+	URL           string     `json:"url"`
+	Title         string     `json:"title"`
+	Summary       string     `json:"summary,omitempty"`
+	ContentHTML   string     `json:"content_html"`
+	DatePublished time.Time  `json:"date_published"`
+	DateModified  *time.Time `json:"date_modified,omitempty"`
+	// Authors is only set for posts by someone other than the author of
+	// the feed.
+	Authors []jsonFeedAuthor `json:"authors,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+// See https://www.jsonfeed.org/version/1.1/.
+type jsonFeedDocument struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url"`
+	FeedURL     string           `json:"feed_url"`
+	Description string           `json:"description,omitempty"`
+	Authors     []jsonFeedAuthor `json:"authors,omitempty"`
+	Items       []jsonFeedItem   `json:"items"`
+}
+
+func buildJSONFeed(cfg *Config, feed *feeds.Feed) ([]byte, error) {
+	doc := jsonFeedDocument{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       feed.Title,
+		HomePageURL: feed.Link.Href,
+		FeedURL:     cfg.BaseURL + "/feed.json",
+		Description: feed.Description,
+		Authors:     []jsonFeedAuthor{{Name: feed.Author.Name, URL: cfg.BaseURL}},
+		Items:       make([]jsonFeedItem, 0, len(feed.Items)),
 	}
-	go func() {
-		if err := httpSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			logger.Error("http server returned, shutting down", slog.String("error", err.Error()))
-			shutdown()
-			return
+	for _, item := range feed.Items {
+		jsonItem := jsonFeedItem{
+			ID:            item.Id,
+			URL:           item.Link.Href,
+			Title:         item.Title,
+			Summary:       item.Description,
+			ContentHTML:   item.Content,
+			DatePublished: item.Created,
 		}
-		logger.Info("http server shutdown")
-	}()
-	defer func() {
-		sctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-		defer cancel()
-		if err := httpSrv.Shutdown(sctx); err != nil {
-			logger.Error("failed to shutdown http server", slog.String("error", err.Error()))
+		if !item.Updated.IsZero() {
+			jsonItem.DateModified = &item.Updated
 		}
-	}()
-	logger.Info("started http server", slog.String("addr", httpAddr))
+		if item.Author != nil && item.Author.Name != feed.Author.Name {
+			jsonItem.Authors = []jsonFeedAuthor{{Name: item.Author.Name}}
+		}
+		doc.Items = append(doc.Items, jsonItem)
+	}
+	return json.Marshal(doc)
+}
 
-	<-ctx.Done()
-	return nil
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
 }
 
-func registerPublicDir(mux *http.ServeMux) error {
-	const dirPath = "static/public"
-	return fs.WalkDir(
-		staticFiles,
-		dirPath,
-		func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				return err
-			} else if d.IsDir() {
-				return nil
-			}
-			trimmed := strings.TrimPrefix(path, dirPath)
-			mux.HandleFunc("GET "+trimmed, func(w http.ResponseWriter, r *http.Request) {
-				http.ServeFileFS(w, r, staticFiles, path)
-			})
-			return nil
-		},
-	)
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// buildSitemap lists the fixed pages, the posts, and the tag and series
+// pages which the posts add up to. The tag and series pages were last
+// modified whenever the most recent of their posts was.
+func buildSitemap(cfg *Config, posts []*post) ([]byte, error) {
+	set := sitemapURLSet{
+		XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9",
+	}
+	for _, path := range []string{"/", "/blog", "/blog/archive", "/blog/tags", "/uses", "/stats"} {
+		set.URLs = append(set.URLs, sitemapURL{Loc: cfg.BaseURL + path})
+	}
+	listings := make(map[string]time.Time)
+	listed := func(path string, p *post) {
+		if modified := p.LastModified(); modified.After(listings[path]) {
+			listings[path] = modified
+		}
+	}
+	for _, p := range posts {
+		set.URLs = append(set.URLs, sitemapURL{
+			Loc:     cfg.BaseURL + "/blog/" + p.Slug,
+			LastMod: p.LastModified().Format(time.DateOnly),
+		})
+		for _, tag := range p.Tags {
+			listed("/blog/tag/"+url.PathEscape(tag), p)
+		}
+		if p.Series != "" {
+			listed("/blog/series/"+url.PathEscape(p.Series), p)
+		}
+	}
+	paths := make([]string, 0, len(listings))
+	for path := range listings {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	for _, path := range paths {
+		set.URLs = append(set.URLs, sitemapURL{
+			Loc:     cfg.BaseURL + path,
+			LastMod: listings[path].Format(time.DateOnly),
+		})
+	}
+	out, err := xml.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// buildRobots generates robots.txt, which points crawlers at the sitemap or
+// asks them to stay away entirely if crawling isn't allowed.
+func buildRobots(cfg *Config) []byte {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	if cfg.AllowCrawling {
+		b.WriteString("Allow: /\n")
+	} else {
+		b.WriteString("Disallow: /\n")
+	}
+	fmt.Fprintf(&b, "\nSitemap: %s/sitemap.xml\n", cfg.BaseURL)
+	return []byte(b.String())
 }
 
 type post struct {
 	Title       string
 	PublishedAt time.Time
+	UpdatedAt   time.Time
 	Slug        string
 	Content     template.HTML
+	Draft       bool
+	// Featured posts are shown above the recent posts on the homepage.
+	Featured    bool
+	ReadMinutes int
+	Words       int
+	TOC         []Heading
+	Tags        []string
+	// Aliases are old paths of the post, which redirect to it.
+	Aliases []string
+	// Cover is the name of the post's header image under public/, if any.
+	Cover       string
+	Summary     string
+	Description string
+	Canonical   string
+	// Author is who wrote the post, the site's author unless the frontmatter
+	// says otherwise, i.e. for guest posts.
+	Author      string
+	AuthorEmail string
+	// Series is the name of the series the post is part SeriesOrder of, if
+	// any, i.e. for multi-part tutorials.
+	Series      string
+	SeriesOrder int
+
+	// source is the markdown the post was rendered from, frontmatter and
+	// all, see serveMarkdown.
+	source []byte
+	// text is the plain text of the post, used for searching.
+	text string
+	// unknownKeys are in the frontmatter, but ignored, see postMeta.Unknown.
+	unknownKeys []string
+}
+
+// LastModified is when the post was last changed, which is when it was
+// published unless it has been updated since.
+func (p *post) LastModified() time.Time {
+	if p.UpdatedAt.After(p.PublishedAt) {
+		return p.UpdatedAt
+	}
+	return p.PublishedAt
+}
+
+type Heading struct {
+	Text  string
+	Level int
+	ID    string
+}
+
+// highlightStyles are the chroma styles used for fenced code blocks in the
+// light and dark themes, see https://xyproto.github.io/splash/docs/ for the
+// available options. Code blocks are rendered with classes rather than
+// inline styles, so the same markup works with either stylesheet.
+var highlightStyles = map[string]string{
+	"light": "github",
+	"dark":  "monokai",
 }
 
 var (
 	mdparser = goldmark.New(
-		goldmark.WithExtensions(&frontmatter.Extender{}),
+		goldmark.WithExtensions(
+			&frontmatter.Extender{},
+			highlighting.NewHighlighting(
+				highlighting.WithStyle(highlightStyles["light"]),
+				highlighting.WithFormatOptions(chromahtml.WithClasses(true)),
+			),
+			mathExtension{},
+			extension.Footnote,
+			// Cell alignment is rendered as an attribute rather than an
+			// inline style, which would be stripped by bmPolicy.
+			extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+			extension.Strikethrough,
+			extension.Linkify,
+			imageExtension{},
+		),
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+			parser.WithASTTransformers(
+				util.Prioritized(headingAnchors{}, 100),
+				util.Prioritized(externalLinks{}, 100),
+			),
+		),
 	)
-	bmPolicy = bluemonday.UGCPolicy()
+	bmPolicy = buildPolicy()
 )
 
-func loadPost(path string) (*post, error) {
-	f, err := staticFiles.Open(path)
+// buildPolicy creates the policy rendered posts are sanitized with. It
+// starts from bluemonday's policy for user generated content, and allows the
+// extra markup the markdown extensions produce, which would otherwise be
+// silently stripped.
+func buildPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	// Highlighted code blocks are styled via classes, see highlightCSS.
+	p.AllowAttrs("class").
+		Matching(regexp.MustCompile(`^[a-zA-Z0-9\- ]+$`)).
+		OnElements("pre", "code", "span")
+	// Display math blocks, see mathExtension, footnotes and heading
+	// anchors, see headingAnchors.
+	p.AllowAttrs("class").
+		Matching(regexp.MustCompile(`^(math display|footnotes)$`)).
+		OnElements("div")
+	p.AllowAttrs("class").
+		Matching(regexp.MustCompile(`^(footnote-ref|footnote-backref|heading-anchor)$`)).
+		OnElements("a")
+	p.AllowAttrs("role").
+		Matching(regexp.MustCompile(`^doc-(noteref|endnotes|backlink)$`)).
+		OnElements("a", "div")
+	// Lazy loaded images, see imageExtension.
+	p.AllowAttrs("loading").
+		Matching(regexp.MustCompile(`^(lazy|eager)$`)).
+		OnElements("img")
+	p.AllowAttrs("decoding").
+		Matching(regexp.MustCompile(`^(async|sync|auto)$`)).
+		OnElements("img")
+	// External links, see externalLinks. The policy adds nofollow on
+	// top, which is allowed too so that sanitizing is idempotent.
+	p.AllowAttrs("target").
+		Matching(regexp.MustCompile(`^_blank$`)).
+		OnElements("a")
+	p.AllowAttrs("rel").
+		Matching(regexp.MustCompile(`^noopener noreferrer( nofollow)?$`)).
+		OnElements("a")
+	return p
+}
+
+// highlightCSS generates the stylesheet for highlighted code blocks in the
+// named chroma style.
+func highlightCSS(name string) ([]byte, error) {
+	style := styles.Get(name)
+	var buf bytes.Buffer
+	if err := chromahtml.New(chromahtml.WithClasses(true)).WriteCSS(&buf, style); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// postMeta is the frontmatter of a post, along with what's derived from the
+// structure of its markdown.
+type postMeta struct {
+	Title       string   `yaml:"title"`
+	Slug        string   `yaml:"slug"`
+	Published   string   `yaml:"published"`
+	Updated     string   `yaml:"updated"`
+	Draft       bool     `yaml:"draft"`
+	Featured    bool     `yaml:"featured"`
+	Tags        []string `yaml:"tags"`
+	Summary     string   `yaml:"summary"`
+	Description string   `yaml:"description"`
+	Canonical   string   `yaml:"canonical"`
+	Cover       string   `yaml:"cover"`
+	Aliases     []string `yaml:"aliases"`
+	Author      string   `yaml:"author"`
+	AuthorEmail string   `yaml:"author_email"`
+	Series      string   `yaml:"series"`
+	SeriesOrder int      `yaml:"series_order"`
+
+	TOC []Heading `yaml:"-"`
+	// Unknown are the keys which none of the fields above are for, most
+	// likely typos, see unknownFrontmatterKeys.
+	Unknown []string `yaml:"-"`
+}
+
+// renderMarkdown renders the markdown source of a post to sanitized html,
+// and extracts its frontmatter. siteHost is the host of the site, so that
+// links elsewhere can be told apart, see externalLinks. Headings up to
+// anchorLevel get a link to themselves, see headingAnchors.
+//
+// It only depends on its arguments, so the same source always renders the
+// same way. Posts are rendered from source every time they're loaded
+// (including when reloading), and the output is never sanitized again.
+func renderMarkdown(source []byte, siteHost string, anchorLevel int) (template.HTML, postMeta, error) {
+	ctx := parser.NewContext(parser.WithIDs(headingIDs{}))
+	ctx.Set(siteHostKey, siteHost)
+	ctx.Set(anchorLevelKey, anchorLevel)
+	doc := mdparser.Parser().Parse(text.NewReader(source), parser.WithContext(ctx))
+
+	var buf bytes.Buffer
+	if err := mdparser.Renderer().Render(&buf, source, doc); err != nil {
+		return "", postMeta{}, fmt.Errorf("rendering markdown: %w", err)
+	}
+
+	var meta postMeta
+	var raw map[string]any
+	if fm := frontmatter.Get(ctx); fm != nil {
+		if err := fm.Decode(&meta); err != nil {
+			return "", postMeta{}, fmt.Errorf("extracting frontmatter: %w", err)
+		}
+		if err := fm.Decode(&raw); err != nil {
+			return "", postMeta{}, fmt.Errorf("extracting frontmatter: %w", err)
+		}
+	}
+	meta.Unknown = unknownFrontmatterKeys(raw)
+	meta.TOC = tableOfContents(doc, source, anchorLevel)
+
+	return template.HTML(bmPolicy.Sanitize(buf.String())), meta, nil
+}
+
+// parsePostDate parses a date from the frontmatter of a post, trying layout
+// and then RFC 3339 and plain dates. The result is always in UTC, so dates
+// compare and format the same regardless of how they were written. Note that
+// time.Parse gives zone abbreviations it doesn't know, like PST outside of
+// the US, a zero offset.
+func parsePostDate(layout, value string) (time.Time, error) {
+	for _, l := range []string{layout, time.RFC3339, time.DateOnly} {
+		if t, err := time.Parse(l, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("'%s' doesn't match the layout %q, or %q or %q", value, layout, time.RFC3339, time.DateOnly)
+}
+
+func loadPost(cfg *Config, fsys fs.FS, path string) (*post, error) {
+	f, err := fsys.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("open: %w", err)
 	}
@@ -275,55 +681,338 @@ func loadPost(path string) (*post, error) {
 		return nil, fmt.Errorf("reading content: %w", err)
 	}
 
-	var buf bytes.Buffer
-
-	ctx := parser.NewContext()
-	if err := mdparser.Convert(content, &buf, parser.WithContext(ctx)); err != nil {
-		return nil, fmt.Errorf("converting markdown: %w", err)
+	var siteHost string
+	if u, err := url.Parse(cfg.BaseURL); err == nil {
+		siteHost = u.Hostname()
+	}
+	rendered, meta, err := renderMarkdown(content, siteHost, cfg.AnchorLevel)
+	if err != nil {
+		return nil, err
 	}
 
-	var meta struct {
-		Title     string `yaml:"title"`
-		Published string `yaml:"published"`
+	var missing []string
+	if strings.TrimSpace(meta.Title) == "" {
+		missing = append(missing, "title")
 	}
-	if err := frontmatter.Get(ctx).Decode(&meta); err != nil {
-		return nil, fmt.Errorf("extracting frontmatter: %w", err)
+	if strings.TrimSpace(meta.Published) == "" {
+		missing = append(missing, "published")
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("frontmatter is missing %s", strings.Join(missing, " and "))
+	}
+	if len(meta.Unknown) > 0 && cfg.StrictFrontmatter {
+		return nil, fmt.Errorf("unknown frontmatter keys: %s", describeUnknownKeys(meta.Unknown))
 	}
 
-	parsed, err := time.Parse("Jan 02 2006 MST", meta.Published)
+	parsed, err := parsePostDate(cfg.DateLayout, meta.Published)
 	if err != nil {
-		return nil, fmt.Errorf("parsing post timestamp '%s': %w", meta.Published, err)
+		return nil, fmt.Errorf("parsing post timestamp: %w", err)
+	}
+
+	if meta.Canonical != "" {
+		u, err := url.Parse(meta.Canonical)
+		if err != nil {
+			return nil, fmt.Errorf("parsing canonical url '%s': %w", meta.Canonical, err)
+		} else if !u.IsAbs() || u.Host == "" {
+			return nil, fmt.Errorf("canonical url '%s' must be absolute", meta.Canonical)
+		}
+	}
+
+	slug := strings.TrimSuffix(filepath.Base(path), ".md")
+	if meta.Slug != "" {
+		if !validSlug.MatchString(meta.Slug) {
+			return nil, fmt.Errorf("slug '%s' must be lowercase letters and digits separated by hyphens", meta.Slug)
+		}
+		slug = meta.Slug
+	}
+
+	for _, alias := range meta.Aliases {
+		if !strings.HasPrefix(alias, "/") {
+			return nil, fmt.Errorf("alias '%s' must be a path starting with /", alias)
+		}
+	}
+
+	series := strings.TrimSpace(meta.Series)
+	if series == "" && meta.SeriesOrder != 0 {
+		return nil, fmt.Errorf("series_order is set, but series isn't")
+	} else if series != "" && meta.SeriesOrder < 1 {
+		return nil, fmt.Errorf("series_order of series '%s' must be at least 1", series)
+	}
+
+	cover := strings.TrimPrefix(meta.Cover, "/")
+	if cover != "" {
+		if _, err := fs.Stat(fsys, "public/"+cover); err != nil {
+			return nil, fmt.Errorf("checking cover image '%s': %w", meta.Cover, err)
+		}
 	}
 
+	var updated time.Time
+	if meta.Updated != "" {
+		updated, err = parsePostDate(cfg.DateLayout, meta.Updated)
+		if err != nil {
+			return nil, fmt.Errorf("parsing post updated timestamp: %w", err)
+		}
+	}
+
+	author, authorEmail := cfg.Author, cfg.AuthorEmail
+	if meta.Author != "" {
+		author, authorEmail = meta.Author, meta.AuthorEmail
+	}
+
+	summary := strings.TrimSpace(meta.Summary)
+	if summary == "" {
+		summary = summarize([]byte(rendered), summaryLength)
+	}
+
+	prose, code := countWords([]byte(rendered))
+
 	return &post{
 		Title:       meta.Title,
 		PublishedAt: parsed,
-		Slug:        strings.TrimSuffix(filepath.Base(path), ".md"),
-		Content:     template.HTML(bmPolicy.Sanitize(buf.String())),
+		UpdatedAt:   updated,
+		Canonical:   meta.Canonical,
+		Cover:       cover,
+		Slug:        slug,
+		Content:     rendered,
+		Draft:       meta.Draft,
+		Featured:    meta.Featured,
+		ReadMinutes: readMinutes(prose, code),
+		Words:       prose + code,
+		TOC:         meta.TOC,
+		Tags:        meta.Tags,
+		Aliases:     meta.Aliases,
+		Summary:     summary,
+		Description: cmp.Or(meta.Description, summary),
+		Author:      author,
+		AuthorEmail: authorEmail,
+		Series:      series,
+		SeriesOrder: meta.SeriesOrder,
+		source:      content,
+		text:        plainText([]byte(rendered)),
+		unknownKeys: meta.Unknown,
 	}, nil
 }
 
-func loadPosts() ([]*post, error) {
-	const dirPath = "static/posts"
-	files, err := fs.ReadDir(staticFiles, dirPath)
-	if err != nil {
-		return nil, fmt.Errorf("read dir %s: %w", dirPath, err)
+// validSlug matches the slugs which can be set in frontmatter, so that they
+// need no escaping in urls.
+var validSlug = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// summaryLength is roughly how long the automatically derived summaries of
+// posts are, which is about what search engines show for descriptions.
+const summaryLength = 160
+
+// summarize extracts roughly the first n characters of the first paragraph
+// from the rendered html of a post, cut at a word boundary. Code blocks,
+// headings and figure captions are skipped.
+func summarize(rendered []byte, n int) string {
+	var words []string
+	var length, skipDepth int
+	z := html.NewTokenizer(bytes.NewReader(rendered))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.Join(words, " ")
+		case html.StartTagToken:
+			switch name, _ := z.TagName(); string(name) {
+			case "pre", "figcaption", "h1", "h2", "h3", "h4", "h5", "h6":
+				skipDepth++
+			}
+		case html.EndTagToken:
+			switch name, _ := z.TagName(); string(name) {
+			case "pre", "figcaption", "h1", "h2", "h3", "h4", "h5", "h6":
+				skipDepth = max(0, skipDepth-1)
+			case "p":
+				if skipDepth == 0 && len(words) > 0 {
+					return strings.Join(words, " ")
+				}
+			}
+		case html.TextToken:
+			if skipDepth > 0 {
+				continue
+			}
+			for _, word := range strings.Fields(html.UnescapeString(string(z.Text()))) {
+				if length+len(word) > n {
+					return strings.Join(words, " ") + "…"
+				}
+				words = append(words, word)
+				length += len(word) + 1
+			}
+		}
+	}
+}
+
+// tableOfContents collects the headings of a parsed post from h2 down to
+// maxLevel, the same ones which get anchors. The IDs are the same ones the
+// renderer attaches to the heading elements, so they can be used directly
+// as in-page anchors.
+func tableOfContents(doc ast.Node, source []byte, maxLevel int) []Heading {
+	var toc []Heading
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		h, ok := n.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		if h.Level < 2 || h.Level > maxLevel {
+			return ast.WalkSkipChildren, nil
+		}
+		id, ok := h.AttributeString("id")
+		if !ok {
+			return ast.WalkSkipChildren, nil
+		}
+		idBytes, _ := id.([]byte)
+		toc = append(toc, Heading{
+			Text:  string(h.Text(source)),
+			Level: h.Level,
+			ID:    string(idBytes),
+		})
+		return ast.WalkSkipChildren, nil
+	})
+	return toc
+}
+
+const (
+	proseWordsPerMinute = 220
+	codeWordsPerMinute  = 100
+)
+
+// readMinutes estimates how long it takes to read a post with the given
+// number of words (see countWords), rounded up to the nearest minute. Code
+// is read at a slower pace than regular prose.
+func readMinutes(prose, code int) int {
+	seconds := (prose*60)/proseWordsPerMinute + (code*60)/codeWordsPerMinute
+	return max(1, (seconds+59)/60)
+}
+
+// countWords counts the words in the rendered html of a post, separately for
+// prose and for the text inside of <pre> blocks.
+func countWords(rendered []byte) (prose, code int) {
+	var preDepth int
+	z := html.NewTokenizer(bytes.NewReader(rendered))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return prose, code
+		case html.StartTagToken:
+			if name, _ := z.TagName(); string(name) == "pre" {
+				preDepth++
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "pre" && preDepth > 0 {
+				preDepth--
+			}
+		case html.TextToken:
+			n := len(strings.Fields(string(z.Text())))
+			if preDepth > 0 {
+				code += n
+			} else {
+				prose += n
+			}
+		}
+	}
+}
+
+// loadPosts loads every post in the posts directory of fsys, including its
+// subdirectories. In dev, any broken post fails the whole load so it can't be
+// missed. In production, broken posts are logged and skipped so one typo
+// doesn't take down the site, unless none of the posts could be loaded at all.
+func loadPosts(cfg *Config, fsys fs.FS, logger *slog.Logger) ([]*post, error) {
+	return loadPostsFrom(cfg, fsys, "posts", runtime.NumCPU(), logger)
+}
+
+// loadPostsFrom is loadPosts for an arbitrary directory, rendering up to
+// workers posts at once.
+func loadPostsFrom(cfg *Config, fsys fs.FS, dirPath string, workers int, logger *slog.Logger) ([]*post, error) {
+	// Posts can be organised into subdirectories, their slugs still only
+	// come from the file name (or frontmatter) though.
+	var files []string
+	if err := fs.WalkDir(fsys, dirPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if !d.IsDir() && strings.HasSuffix(path, ".md") {
+			files = append(files, path)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("walking %s: %w", dirPath, err)
+	}
+
+	type result struct {
+		post *post
+		err  error
+	}
+	results := make([]result, len(files))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range max(1, workers) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				p, err := loadPost(cfg, fsys, files[i])
+				results[i] = result{p, err}
+			}
+		}()
 	}
+	for i := range files {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
 
-	var posts []*post
-	for _, f := range files {
-		p := filepath.Join(dirPath, f.Name())
-		loaded, err := loadPost(p)
+	var (
+		posts []*post
+		errs  []error
+		// Slugs can be set in frontmatter and posts can be in different
+		// directories, so two files can end up with the same one.
+		slugFiles = make(map[string]string)
+		// partFiles is keyed by series, then by order within it.
+		partFiles = make(map[string]map[int]string)
+	)
+	for i, file := range files {
+		name := strings.TrimPrefix(file, dirPath+"/")
+		loaded, err := results[i].post, results[i].err
 		if err != nil {
-			return nil, fmt.Errorf("loading %s: %w", f.Name(), err)
+			err = fmt.Errorf("loading %s: %w", name, err)
+			if !production() {
+				return nil, err
+			}
+			logger.Error("skipping broken post", slog.String("post", name), slog.String("error", err.Error()))
+			errs = append(errs, err)
+			continue
+		}
+		if other, ok := slugFiles[loaded.Slug]; ok {
+			return nil, fmt.Errorf("%s and %s both have the slug %s", other, name, loaded.Slug)
+		}
+		slugFiles[loaded.Slug] = name
+		if loaded.Series != "" {
+			parts := partFiles[loaded.Series]
+			if parts == nil {
+				parts = make(map[int]string)
+				partFiles[loaded.Series] = parts
+			}
+			if other, ok := parts[loaded.SeriesOrder]; ok {
+				return nil, fmt.Errorf("%s and %s are both part %d of series %s", other, name, loaded.SeriesOrder, loaded.Series)
+			}
+			parts[loaded.SeriesOrder] = name
+		}
+		if len(loaded.unknownKeys) > 0 {
+			logger.Warn("ignoring unknown frontmatter keys", slog.String("post", name), slog.String("keys", describeUnknownKeys(loaded.unknownKeys)))
 		}
 		posts = append(posts, loaded)
 	}
 
 	slices.SortFunc(posts, func(a, b *post) int {
-		return cmp.Compare(a.PublishedAt.Unix(), b.PublishedAt.Unix()) * -1
+		return cmp.Or(
+			cmp.Compare(a.PublishedAt.Unix(), b.PublishedAt.Unix())*-1,
+			cmp.Compare(a.Slug, b.Slug),
+		)
 	})
 
+	if len(posts) == 0 && len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	logger.Info("loaded posts", slog.Int("loaded", len(posts)), slog.Int("skipped", len(errs)))
+
 	return posts, nil
 }
 
@@ -331,57 +1020,456 @@ type templateSet struct {
 	tmpls map[string]*template.Template
 }
 
+// exec renders the template id into w. In production the output is also
+// minified, whereas in dev it is left as-is to make debugging easier.
 func (ts *templateSet) exec(w io.Writer, id string, data any) error {
 	t, ok := ts.tmpls[id]
 	if !ok {
 		return fmt.Errorf("missing template %s", id)
 	}
-	return t.ExecuteTemplate(w, "base", data)
+	if !production() {
+		return t.ExecuteTemplate(w, "base", data)
+	}
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, "base", data); err != nil {
+		return err
+	}
+	if err := htmlMinifier.Minify("text/html", w, &buf); err != nil {
+		return fmt.Errorf("minifying template %s: %w", id, err)
+	}
+	return nil
 }
 
-type templateDataFunc func(*http.Request) (any, error)
+// htmlMinifier strips comments and collapses whitespace in rendered pages.
+// Whitespace in <pre> blocks is preserved, so code samples stay intact.
+var htmlMinifier = func() *minify.M {
+	m := minify.New()
+	m.Add("text/html", &minhtml.Minifier{
+		KeepDocumentTags: true,
+		KeepEndTags:      true,
+		KeepQuotes:       true,
+	})
+	return m
+}()
+
+type templateDataFunc func(*http.Request, *siteContent) (any, error)
 
 type templateData[T any] struct {
 	Inner    T
 	Subtitle string
+	Meta     pageMeta
+	commonData
+}
+
+func (d templateData[T]) withCommon(common commonData) any {
+	d.commonData = common
+	return d
+}
+
+// commonData is what every page has, regardless of the handler. It's set by
+// registerHandler and the error pages, see contentStore.common.
+type commonData struct {
+	// Theme is from the request, see themePreference.
+	Theme string
+	// Updated is when the site's content last changed, and Built is when it
+	// was last built, i.e. at startup or when it was last reloaded.
+	Updated time.Time
+	Built   time.Time
+}
+
+func (s *contentStore) common(r *http.Request) commonData {
+	c := s.forRequest(r)
+	return commonData{
+		Theme:   themeFrom(r),
+		Updated: cmp.Or(c.lastModified, c.builtAt),
+		Built:   c.builtAt,
+	}
+}
+
+// pageMeta is rendered into the OpenGraph and Twitter Card tags of a page,
+// empty fields fall back to the site defaults in the base template.
+type pageMeta struct {
+	Title       string
+	Description string
+	URL         string
+	Canonical   string // Defaults to URL.
+	Image       string
+	Type        string
+	// NoIndex asks search engines not to index the page, i.e. for previews
+	// of drafts.
+	NoIndex bool
+	// Prev and Next are the absolute urls of the pages before and after a
+	// paginated page, if there are any.
+	Prev, Next string
+}
+
+// blogPosting is the schema.org description of a post, embedded in its page
+// as JSON-LD so search engines can show the title, author and dates.
+type blogPosting struct {
+	Context       string       `json:"@context"`
+	Type          string       `json:"@type"`
+	Headline      string       `json:"headline"`
+	Description   string       `json:"description,omitempty"`
+	Image         string       `json:"image,omitempty"`
+	DatePublished string       `json:"datePublished"`
+	DateModified  string       `json:"dateModified"`
+	Author        schemaPerson `json:"author"`
+	URL           string       `json:"url"`
+}
+
+type schemaPerson struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+}
+
+// structuredData marshals the blogPosting for p. encoding/json escapes <, >
+// and &, so the result can't close the script tag it's rendered into.
+func structuredData(cfg *Config, p *post) (template.JS, error) {
+	b, err := json.Marshal(blogPosting{
+		Context:       "https://schema.org",
+		Type:          "BlogPosting",
+		Headline:      p.Title,
+		Description:   p.Description,
+		Image:         cfg.publicURL(p.Cover),
+		DatePublished: p.PublishedAt.Format(time.RFC3339),
+		DateModified:  p.LastModified().Format(time.RFC3339),
+		Author:        schemaPerson{Type: "Person", Name: p.Author},
+		URL:           cmp.Or(p.Canonical, cfg.BaseURL+"/blog/"+p.Slug),
+	})
+	if err != nil {
+		return "", err
+	}
+	return template.JS(b), nil
 }
 
 var errNotFound = errors.New("not found")
 
-func (ts *templateSet) registerHandler(
+type pagination struct {
+	Current int
+	Total   int
+	HasPrev bool
+	HasNext bool
+
+	start, end int
+}
+
+func (p pagination) Prev() int { return p.Current - 1 }
+func (p pagination) Next() int { return p.Current + 1 }
+
+// paginate works out which slice of n items to show for the "page" query
+// parameter of r, pages are 1-indexed and default to the first one. Pages
+// which don't exist result in errNotFound.
+func paginate(r *http.Request, n, perPage int) (pagination, error) {
+	current := 1
+	if raw := r.URL.Query().Get("page"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return pagination{}, errNotFound
+		}
+		current = parsed
+	}
+	total := max(1, (n+perPage-1)/perPage)
+	if current < 1 || current > total {
+		return pagination{}, errNotFound
+	}
+	return pagination{
+		Current: current,
+		Total:   total,
+		HasPrev: current > 1,
+		HasNext: current < total,
+		start:   (current - 1) * perPage,
+		end:     min(n, current*perPage),
+	}, nil
+}
+
+// dateFilter narrows the blog index down to the posts published in a year,
+// or a month of it, via the "year" and "month" query parameters.
+type dateFilter struct {
+	Year  int
+	Month time.Month // Zero for the whole year.
+}
+
+// parseDateFilter reads the filter from the query of r. Invalid values are
+// ignored, as is a month without a year, in which case every post is shown.
+func parseDateFilter(r *http.Request) dateFilter {
+	q := r.URL.Query()
+	year, err := strconv.Atoi(q.Get("year"))
+	if err != nil || year < 1 || year > 9999 {
+		return dateFilter{}
+	}
+	f := dateFilter{Year: year}
+	if month, err := strconv.Atoi(q.Get("month")); err == nil && month >= 1 && month <= 12 {
+		f.Month = time.Month(month)
+	}
+	return f
+}
+
+func (f dateFilter) Active() bool { return f.Year != 0 }
+
+func (f dateFilter) match(t time.Time) bool {
+	return !f.Active() || (t.Year() == f.Year && (f.Month == 0 || t.Month() == f.Month))
+}
+
+// String describes the filter, i.e. "March 2024".
+func (f dateFilter) String() string {
+	if f.Month == 0 {
+		return strconv.Itoa(f.Year)
+	}
+	return fmt.Sprintf("%s %d", f.Month, f.Year)
+}
+
+// blogSorts are the orders the blog index can be sorted in via the "sort"
+// query parameter, the first of which is the default.
+var blogSorts = []string{"newest", "oldest", "title"}
+
+// parseBlogSort reads the sort order from the query of r, unknown orders
+// are ignored in favour of the default.
+func parseBlogSort(r *http.Request) string {
+	if sort := r.URL.Query().Get("sort"); slices.Contains(blogSorts, sort) {
+		return sort
+	}
+	return blogSorts[0]
+}
+
+// sortPosts returns posts, which is expected to be sorted newest first, in
+// the given order. The posts are copied before being reordered, since the
+// slice is shared between requests.
+func sortPosts(posts []*post, sort string) []*post {
+	switch sort {
+	case "oldest":
+		posts = slices.Clone(posts)
+		slices.Reverse(posts)
+	case "title":
+		posts = slices.Clone(posts)
+		slices.SortStableFunc(posts, func(a, b *post) int {
+			return cmp.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title))
+		})
+	}
+	return posts
+}
+
+// blogQuery is how the blog index is being viewed, which is kept when
+// linking to other pages of it.
+type blogQuery struct {
+	Filter dateFilter
+	Sort   string
+}
+
+// URL links to a page of the blog index, keeping the filter and order.
+func (q blogQuery) URL(page int) string {
+	v := url.Values{}
+	if q.Filter.Active() {
+		v.Set("year", strconv.Itoa(q.Filter.Year))
+		if q.Filter.Month != 0 {
+			v.Set("month", fmt.Sprintf("%02d", q.Filter.Month))
+		}
+	}
+	if q.Sort != "" && q.Sort != blogSorts[0] {
+		v.Set("sort", q.Sort)
+	}
+	if page > 1 {
+		v.Set("page", strconv.Itoa(page))
+	}
+	if len(v) == 0 {
+		return "/blog"
+	}
+	return "/blog?" + v.Encode()
+}
+
+// SortURL links to the first page of the blog index in the given order,
+// keeping the filter.
+func (q blogQuery) SortURL(sort string) string {
+	q.Sort = sort
+	return q.URL(1)
+}
+
+func (s *contentStore) registerHandler(
 	mux *http.ServeMux,
 	pattern, tmpl string,
 	dataFn templateDataFunc,
 ) error {
-	if _, ok := ts.tmpls[tmpl]; !ok {
-		return fmt.Errorf("missing template %s", tmpl)
+	handler, err := s.templateHandler(tmpl, dataFn)
+	if err != nil {
+		return err
 	}
-	mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc(pattern, handler)
+	return nil
+}
+
+// templateHandler renders tmpl with the data from dataFn, for when the
+// handler can't be registered with a pattern of its own, see
+// registerHandler.
+func (s *contentStore) templateHandler(tmpl string, dataFn templateDataFunc) (http.HandlerFunc, error) {
+	if _, ok := s.get().templates.tmpls[tmpl]; !ok {
+		return nil, fmt.Errorf("missing template %s", tmpl)
+	}
+	s.markRendered(tmpl)
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := s.forRequest(r)
 		var data any
 		if dataFn != nil {
-			d, err := dataFn(r)
+			d, err := dataFn(r, c)
 			if errors.Is(err, errNotFound) {
-				http.NotFound(w, r)
+				s.notFound(w, r)
 				return
 			} else if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				s.serverError(w, r, err)
 				return
 			}
 			data = d
 		}
-		if err := ts.exec(w, tmpl, data); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if d, ok := data.(interface{ withCommon(commonData) any }); ok {
+			data = d.withCommon(s.common(r))
+		}
+		if err := c.render(w, tmpl, http.StatusOK, data); err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+	}, nil
+}
+
+// markRendered records that a handler renders tmpl, see warnUnusedTemplates.
+func (s *contentStore) markRendered(tmpl string) {
+	if s.rendered == nil {
+		s.rendered = make(map[string]bool)
+	}
+	s.rendered[tmpl] = true
+}
+
+// registerErrorPages checks that the error templates and the maintenance
+// page exist, and renders the 404 template for any request which doesn't
+// match another pattern on mux.
+// Requests for a path which does exist, but with a different method, get a
+// 405 instead, since the catch-all otherwise stops the mux from sending one.
+func (s *contentStore) registerErrorPages(mux *http.ServeMux) error {
+	for _, tmpl := range []string{notFoundTemplate, serverErrorTemplate, maintenanceTemplate} {
+		if _, ok := s.get().templates.tmpls[tmpl]; !ok {
+			return fmt.Errorf("missing template %s", tmpl)
+		}
+		s.markRendered(tmpl)
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if allowed := allowedMethods(mux, r); len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 			return
 		}
+		s.notFound(w, r)
 	})
 	return nil
 }
 
+// allowedMethods returns the methods which r's path is registered for on
+// mux, excluding the catch-all pattern.
+func allowedMethods(mux *http.ServeMux, r *http.Request) []string {
+	var allowed []string
+	for _, method := range []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodPost,
+		http.MethodPut,
+		http.MethodPatch,
+		http.MethodDelete,
+	} {
+		if method == r.Method {
+			continue
+		}
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		if _, pattern := mux.Handler(probe); pattern != "/" && pattern != "" {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+const (
+	notFoundTemplate    = "404"
+	serverErrorTemplate = "500"
+)
+
+// notFound renders the 404 template, unless the path has been redirected
+// elsewhere. Redirects are checked here rather than in the catch-all, since
+// an old post slug still matches the pattern of the post handler.
+func (s *contentStore) notFound(w http.ResponseWriter, r *http.Request) {
+	c := s.forRequest(r)
+	if to, ok := c.redirects[r.URL.Path]; ok && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		if r.URL.RawQuery != "" {
+			to += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, to, http.StatusMovedPermanently)
+		return
+	}
+	// Posts with a similar slug are suggested, in case the url was mistyped
+	// or cut short.
+	type innerType struct {
+		Suggestions []*post
+	}
+	var inner innerType
+	if slug, ok := strings.CutPrefix(r.URL.Path, "/blog/"); ok && !strings.Contains(slug, "/") {
+		inner.Suggestions = suggestPosts(c.posts, slug)
+	}
+	if err := c.render(w, notFoundTemplate, http.StatusNotFound, templateData[innerType]{
+		Inner:      inner,
+		Subtitle:   "Not found",
+		commonData: s.common(r),
+	}); err != nil {
+		s.serverError(w, r, err)
+	}
+}
+
+// serverError logs err and renders the 500 template.
+func (s *contentStore) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	s.logger.Error(
+		"failed to handle request",
+		slog.String("path", r.URL.Path),
+		slog.String("error", err.Error()),
+	)
+	s.renderServerError(w, r, err)
+}
+
+// renderServerError renders the 500 template. The error itself is only
+// shown to the visitor in dev, since it may leak internal details.
+func (s *contentStore) renderServerError(w http.ResponseWriter, r *http.Request, err error) {
+	var detail string
+	if !production() {
+		detail = err.Error()
+	}
+	if err := s.forRequest(r).render(w, serverErrorTemplate, http.StatusInternalServerError, templateData[string]{
+		Inner:      detail,
+		Subtitle:   "Error",
+		commonData: s.common(r),
+	}); err != nil {
+		s.logger.Error("failed to render error page", slog.String("error", err.Error()))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// render executes a template into a buffer before writing anything out, so
+// that nothing is sent to the client if execution fails halfway.
+func (c *siteContent) render(w http.ResponseWriter, tmpl string, status int, data any) error {
+	var buf bytes.Buffer
+	if err := c.templates.exec(&buf, tmpl, data); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	_, _ = w.Write(buf.Bytes())
+	return nil
+}
+
 const templateExt = ".tmpl.html"
 
-func loadTemplates() (*templateSet, error) {
-	const dirPath = "static/templates"
-	sub, err := fs.Sub(staticFiles, dirPath)
+// partialsDir holds templates which can be included by name from any page,
+// e.g. {{template "footer" .}}.
+const partialsDir = "partials"
+
+// loadTemplates parses every page template in the templates directory of
+// fsys together with the base template.
+func loadTemplates(cfg *Config, fsys fs.FS, assets *assetManifest) (*templateSet, error) {
+	const dirPath = "templates"
+	sub, err := fs.Sub(fsys, dirPath)
 	if err != nil {
 		return nil, fmt.Errorf("sub-fs load template dir: %w", err)
 	}
@@ -393,23 +1481,44 @@ func loadTemplates() (*templateSet, error) {
 		return nil, fmt.Errorf("checking for base template %s: %w", baseName, err)
 	}
 
+	// The base template and the partials shared between pages are parsed
+	// once, and every page is then parsed on top of a clone of them.
+	shared := []string{baseName}
+	partials, err := fs.Glob(sub, partialsDir+"/*"+templateExt)
+	if err != nil {
+		return nil, fmt.Errorf("finding partials: %w", err)
+	}
+	shared = append(shared, partials...)
+	root, err := template.New("base").Funcs(templateFuncs(cfg, assets)).ParseFS(sub, shared...)
+	if err != nil {
+		return nil, fmt.Errorf("parsing base template and partials: %w", err)
+	}
+
 	tmpls := make(map[string]*template.Template)
 	if err := fs.WalkDir(sub, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
+		} else if d.IsDir() && path == partialsDir {
+			return fs.SkipDir
 		}
 		name := d.Name()
 		if d.IsDir() || !strings.HasSuffix(name, templateExt) || name == baseName {
 			return nil
 		}
 		id := strings.TrimSuffix(path, templateExt)
-		tmpl, err := template.New(id).ParseFS(
-			sub,
-			[]string{path, baseName}...,
-		)
+		tmpl, err := root.Clone()
 		if err != nil {
+			return fmt.Errorf("cloning base template for %s: %w", path, err)
+		}
+		if tmpl, err = tmpl.ParseFS(sub, path); err != nil {
 			return fmt.Errorf("creating template at %s: %w", path, err)
 		}
+		if err := assets.validate(tmpl); err != nil {
+			return fmt.Errorf("checking assets of template at %s: %w", path, err)
+		}
+		if err := dryRun(tmpl); err != nil {
+			return fmt.Errorf("dry run of template at %s: %w", path, err)
+		}
 		tmpls[id] = tmpl
 		return nil
 	}); err != nil {
@@ -418,3 +1527,19 @@ func loadTemplates() (*templateSet, error) {
 
 	return &templateSet{tmpls}, nil
 }
+
+// dryRun renders base with zero-value data to io.Discard, so a page which
+// references a template that isn't defined fails at startup rather than
+// with a 500. Before executing anything, html/template escapes every
+// template the page can reach, whichever branches the data would take, and
+// that's what fails on undefined templates (i.e. partials, or the "content"
+// block every page has to define for base). Errors from then on are down to
+// the zero-value data, so are ignored.
+func dryRun(tmpl *template.Template) error {
+	err := tmpl.ExecuteTemplate(io.Discard, "base", templateData[struct{}]{})
+	var escapeErr *template.Error
+	if errors.As(err, &escapeErr) {
+		return escapeErr
+	}
+	return nil
+}
@@ -0,0 +1,787 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// testConfig is the config used when loading posts in tests.
+var testConfig = &Config{
+	Author:            "Morgan Gallant",
+	BaseURL:           "https://morgangallant.com",
+	DateLayout:        "Jan 02 2006 MST",
+	MaxBodySize:       64 << 10,
+	TagOrder:          "name",
+	AnchorLevel:       3,
+	PostsPerPage:      10,
+	SourceFrontmatter: true,
+	// Long enough for the webmention tests to fetch their sources.
+	RequestTimeout: 5 * time.Second,
+}
+
+// syntheticPosts creates an in-memory posts directory with n posts of a
+// few hundred words each, including a code block.
+func syntheticPosts(n int) fstest.MapFS {
+	fsys := make(fstest.MapFS, n)
+	body := strings.Repeat("Some words in a paragraph, with *emphasis* and a [link](/blog).\n\n", 40)
+	for i := range n {
+		content := fmt.Sprintf(`---
+title: "Post %d"
+published: "Feb %02d 2023 PST"
+tags: [go, web]
+---
+
+## Heading
+
+%s
+`+"```go\nfunc main() { fmt.Println(%d) }\n```\n", i, i%28+1, body, i)
+		fsys[fmt.Sprintf("posts/post-%d.md", i)] = &fstest.MapFile{Data: []byte(content)}
+	}
+	return fsys
+}
+
+func BenchmarkLoadPosts(b *testing.B) {
+	fsys := syntheticPosts(100)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	counts := []int{1}
+	if n := runtime.NumCPU(); n > 1 {
+		counts = append(counts, n)
+	}
+	for _, workers := range counts {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for range b.N {
+				posts, err := loadPostsFrom(testConfig, fsys, "posts", workers, logger)
+				if err != nil {
+					b.Fatal(err)
+				} else if len(posts) != 100 {
+					b.Fatalf("expected 100 posts, got %d", len(posts))
+				}
+			}
+		})
+	}
+}
+
+func TestFootnotes(t *testing.T) {
+	fsys := fstest.MapFS{"posts/footnotes.md": &fstest.MapFile{Data: []byte(`---
+title: "Footnotes"
+published: "Feb 18 2023 PST"
+---
+
+First claim[^1], second claim[^note].
+
+[^1]: The first source.
+[^note]: The second source.
+`)}}
+	p, err := loadPost(testConfig, fsys, "posts/footnotes.md")
+	if err != nil {
+		t.Fatalf("loading post: %v", err)
+	}
+	content := string(p.Content)
+	for _, want := range []string{
+		`<sup id="fnref:1"><a href="#fn:1" class="footnote-ref" role="doc-noteref"`,
+		`<sup id="fnref:2"><a href="#fn:2" class="footnote-ref" role="doc-noteref"`,
+		`<div class="footnotes" role="doc-endnotes">`,
+		`<li id="fn:1">`,
+		`<li id="fn:2">`,
+		`<a href="#fnref:1" class="footnote-backref" role="doc-backlink"`,
+		`<a href="#fnref:2" class="footnote-backref" role="doc-backlink"`,
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("rendered post is missing %q, got:\n%s", want, content)
+		}
+	}
+}
+
+func TestTables(t *testing.T) {
+	fsys := fstest.MapFS{"posts/tables.md": &fstest.MapFile{Data: []byte(`---
+title: "Tables"
+published: "Feb 18 2023 PST"
+---
+
+| Name | Count |
+|:-----|------:|
+| Go   | ~~1~~ 2 |
+
+See https://go.dev for more.
+`)}}
+	p, err := loadPost(testConfig, fsys, "posts/tables.md")
+	if err != nil {
+		t.Fatalf("loading post: %v", err)
+	}
+	content := string(p.Content)
+	for _, want := range []string{
+		"<table>",
+		"<thead>",
+		`<th align="left">Name</th>`,
+		`<th align="right">Count</th>`,
+		"<tbody>",
+		`<td align="left">Go</td>`,
+		`<td align="right"><del>1</del> 2</td>`,
+		`<a href="https://go.dev" target="_blank" rel="noopener noreferrer nofollow">https://go.dev</a>`,
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("rendered post is missing %q, got:\n%s", want, content)
+		}
+	}
+}
+
+func TestSummary(t *testing.T) {
+	long := strings.Repeat("word ", 50)
+	fsys := fstest.MapFS{
+		"posts/derived.md": &fstest.MapFile{Data: []byte(`---
+title: "Derived"
+published: "Feb 18 2023 PST"
+---
+
+## Intro
+
+The *first* paragraph.
+
+The second paragraph.
+`)},
+		"posts/long.md": &fstest.MapFile{Data: []byte(`---
+title: "Long"
+published: "Feb 18 2023 PST"
+---
+
+` + long)},
+		"posts/explicit.md": &fstest.MapFile{Data: []byte(`---
+title: "Explicit"
+published: "Feb 18 2023 PST"
+summary: "  Written by hand.  "
+---
+
+The first paragraph.
+`)},
+	}
+	for _, tc := range []struct {
+		path, want string
+	}{
+		{"posts/derived.md", "The first paragraph."},
+		{"posts/long.md", strings.Repeat("word ", 31) + "word…"},
+		{"posts/explicit.md", "Written by hand."},
+	} {
+		p, err := loadPost(testConfig, fsys, tc.path)
+		if err != nil {
+			t.Fatalf("loading %s: %v", tc.path, err)
+		}
+		if p.Summary != tc.want {
+			t.Errorf("summary of %s = %q, want %q", tc.path, p.Summary, tc.want)
+		}
+		if p.Description != p.Summary {
+			t.Errorf("description of %s = %q, want the summary", tc.path, p.Description)
+		}
+	}
+}
+
+func TestCover(t *testing.T) {
+	fsys := fstest.MapFS{
+		"public/covers/gopher.png": &fstest.MapFile{Data: []byte("png")},
+		"posts/cover.md": &fstest.MapFile{Data: []byte(`---
+title: "Cover"
+published: "Feb 18 2023 PST"
+cover: "/covers/gopher.png"
+---
+
+Hello.
+`)},
+		"posts/missing.md": &fstest.MapFile{Data: []byte(`---
+title: "Missing"
+published: "Feb 18 2023 PST"
+cover: "covers/nope.png"
+---
+
+Hello.
+`)},
+	}
+	p, err := loadPost(testConfig, fsys, "posts/cover.md")
+	if err != nil {
+		t.Fatalf("loading post: %v", err)
+	}
+	if p.Cover != "covers/gopher.png" {
+		t.Errorf("cover = %q, want %q", p.Cover, "covers/gopher.png")
+	}
+	if _, err := loadPost(testConfig, fsys, "posts/missing.md"); err == nil {
+		t.Error("expected an error for a missing cover image")
+	}
+}
+
+func TestRobots(t *testing.T) {
+	for _, tc := range []struct {
+		allow bool
+		want  string
+	}{
+		{true, "User-agent: *\nAllow: /\n\nSitemap: https://morgangallant.com/sitemap.xml\n"},
+		{false, "User-agent: *\nDisallow: /\n\nSitemap: https://morgangallant.com/sitemap.xml\n"},
+	} {
+		cfg := *testConfig
+		cfg.AllowCrawling = tc.allow
+		if got := string(buildRobots(&cfg)); got != tc.want {
+			t.Errorf("buildRobots with AllowCrawling %t = %q, want %q", tc.allow, got, tc.want)
+		}
+	}
+}
+
+func TestStructuredData(t *testing.T) {
+	p := &post{
+		Title:       `Closing </script><script>alert(1)</script> tags`,
+		Slug:        "closing-tags",
+		Author:      "Guest Writer",
+		PublishedAt: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC),
+		UpdatedAt:   time.Date(2023, 3, 4, 0, 0, 0, 0, time.UTC),
+	}
+	ld, err := structuredData(testConfig, p)
+	if err != nil {
+		t.Fatalf("structuredData: %v", err)
+	}
+
+	tmpl := template.Must(template.New("").Parse(`<script type="application/ld+json">{{.}}</script>`))
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, ld); err != nil {
+		t.Fatalf("executing template: %v", err)
+	}
+	out := buf.String()
+	if strings.Count(out, "</script>") != 1 {
+		t.Fatalf("structured data broke out of the script tag:\n%s", out)
+	}
+
+	var got blogPosting
+	jsonText := strings.TrimSuffix(strings.TrimPrefix(out, `<script type="application/ld+json">`), "</script>")
+	if err := json.Unmarshal([]byte(jsonText), &got); err != nil {
+		t.Fatalf("unmarshaling %s: %v", jsonText, err)
+	}
+	want := blogPosting{
+		Context:       "https://schema.org",
+		Type:          "BlogPosting",
+		Headline:      p.Title,
+		DatePublished: "2023-01-02T00:00:00Z",
+		DateModified:  "2023-03-04T00:00:00Z",
+		Author:        schemaPerson{Type: "Person", Name: "Guest Writer"},
+		URL:           "https://morgangallant.com/blog/closing-tags",
+	}
+	if got != want {
+		t.Errorf("structured data = %+v, want %+v", got, want)
+	}
+}
+
+func TestSlug(t *testing.T) {
+	post := func(slug string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(`---
+title: "Slug"
+published: "Feb 18 2023 PST"
+slug: "` + slug + `"
+---
+
+Hello.
+`)}
+	}
+	fsys := fstest.MapFS{
+		"posts/2023-02-18-nicer.md": post("nicer-slug"),
+		"posts/filename.md":         post(""),
+		"posts/spaces.md":           post("has spaces"),
+		"posts/upper.md":            post("Upper-Case"),
+		"posts/hyphens.md":          post("double--hyphen"),
+	}
+	for path, want := range map[string]string{
+		"posts/2023-02-18-nicer.md": "nicer-slug",
+		"posts/filename.md":         "filename",
+	} {
+		p, err := loadPost(testConfig, fsys, path)
+		if err != nil {
+			t.Fatalf("loading %s: %v", path, err)
+		}
+		if p.Slug != want {
+			t.Errorf("%s has slug %q, want %q", path, p.Slug, want)
+		}
+	}
+	for _, path := range []string{"posts/spaces.md", "posts/upper.md", "posts/hyphens.md"} {
+		if _, err := loadPost(testConfig, fsys, path); err == nil {
+			t.Errorf("expected an error for the slug of %s", path)
+		}
+	}
+
+	dupes := fstest.MapFS{
+		"posts/nicer-slug.md": post(""),
+		"posts/other.md":      post("nicer-slug"),
+	}
+	_, err := loadPostsFrom(testConfig, dupes, "posts", 1, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err == nil || !strings.Contains(err.Error(), "nicer-slug.md and other.md both have the slug nicer-slug") {
+		t.Errorf("loading posts with duplicate slugs = %v", err)
+	}
+}
+
+func TestNestedPosts(t *testing.T) {
+	post := func(title string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(`---
+title: "` + title + `"
+published: "Feb 18 2023 PST"
+---
+
+Hello.
+`)}
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	posts, err := loadPostsFrom(testConfig, fstest.MapFS{
+		"posts/top.md":              post("Top"),
+		"posts/2023/nested.md":      post("Nested"),
+		"posts/2023/02/deeper.md":   post("Deeper"),
+		"posts/2023/notes.txt":      &fstest.MapFile{Data: []byte("not a post")},
+		"posts/2024/images/cat.png": &fstest.MapFile{Data: []byte("png")},
+	}, "posts", 2, logger)
+	if err != nil {
+		t.Fatalf("loading posts: %v", err)
+	}
+	var slugs []string
+	for _, p := range posts {
+		slugs = append(slugs, p.Slug)
+	}
+	if got, want := strings.Join(slugs, ","), "deeper,nested,top"; got != want {
+		t.Errorf("slugs = %s, want %s", got, want)
+	}
+
+	_, err = loadPostsFrom(testConfig, fstest.MapFS{
+		"posts/2023/hello.md": post("Hello"),
+		"posts/2024/hello.md": post("Hello again"),
+	}, "posts", 2, logger)
+	if err == nil || !strings.Contains(err.Error(), "2023/hello.md and 2024/hello.md both have the slug hello") {
+		t.Errorf("loading posts with the same name in different directories = %v", err)
+	}
+}
+
+func TestTemplatePartials(t *testing.T) {
+	fsys := fstest.MapFS{
+		"public/styles.css":                 &fstest.MapFile{Data: []byte("body {}")},
+		"templates/base.tmpl.html":          &fstest.MapFile{Data: []byte(`{{define "base"}}{{template "nav" .}}|{{template "content" .}}{{end}}`)},
+		"templates/partials/nav.tmpl.html":  &fstest.MapFile{Data: []byte(`{{define "nav"}}<nav>{{.}}</nav>{{end}}`)},
+		"templates/partials/card.tmpl.html": &fstest.MapFile{Data: []byte(`{{define "card"}}<div>{{.}}</div>{{end}}`)},
+		"templates/first.tmpl.html":         &fstest.MapFile{Data: []byte(`{{define "content"}}first:{{template "card" "a"}}{{end}}`)},
+		"templates/second.tmpl.html":        &fstest.MapFile{Data: []byte(`{{define "content"}}second:<link href="{{asset "styles.css"}}">{{end}}`)},
+	}
+	assets, err := registerPublicDir(http.NewServeMux(), fsys, "")
+	if err != nil {
+		t.Fatalf("registering public dir: %v", err)
+	}
+	ts, err := loadTemplates(testConfig, fsys, assets)
+	if err != nil {
+		t.Fatalf("loading templates: %v", err)
+	}
+	if _, ok := ts.tmpls["partials/nav"]; ok {
+		t.Error("partials shouldn't be loaded as pages")
+	}
+	for id, want := range map[string]string{
+		"first":  "<nav>x</nav>|first:<div>a</div>",
+		"second": "<nav>x</nav>|second:<link href=\"" + assets.urls["styles.css"] + "\">",
+	} {
+		var buf strings.Builder
+		if err := ts.exec(&buf, id, "x"); err != nil {
+			t.Fatalf("executing %s: %v", id, err)
+		}
+		if got := buf.String(); got != want {
+			t.Errorf("%s rendered %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestMissingPartial(t *testing.T) {
+	fsys := fstest.MapFS{
+		"templates/base.tmpl.html": &fstest.MapFile{Data: []byte(`{{define "base"}}{{template "content" .}}{{end}}`)},
+		"templates/page.tmpl.html": &fstest.MapFile{Data: []byte(`{{define "content"}}{{if .}}{{template "sidebar" .}}{{end}}{{end}}`)},
+	}
+	_, err := loadTemplates(testConfig, fsys, &assetManifest{urls: map[string]string{}})
+	if err == nil || !strings.Contains(err.Error(), `"sidebar"`) {
+		t.Fatalf("expected an error naming the missing partial, got %v", err)
+	}
+
+	// Data which a dry run doesn't have doesn't hide the missing partial,
+	// and isn't an error by itself.
+	fsys["templates/page.tmpl.html"] = &fstest.MapFile{Data: []byte(`{{define "content"}}{{range .Inner.Posts}}{{.Title}}{{end}}{{template "sidebar" .}}{{end}}`)}
+	_, err = loadTemplates(testConfig, fsys, &assetManifest{urls: map[string]string{}})
+	if err == nil || !strings.Contains(err.Error(), `"sidebar"`) {
+		t.Fatalf("expected an error naming the missing partial after a field access, got %v", err)
+	}
+	fsys["templates/page.tmpl.html"] = &fstest.MapFile{Data: []byte(`{{define "content"}}{{range .Inner.Posts}}{{.Title}}{{end}}{{end}}`)}
+	if _, err := loadTemplates(testConfig, fsys, &assetManifest{urls: map[string]string{}}); err != nil {
+		t.Fatalf("loading a page which only needs data = %v", err)
+	}
+
+	// A page which doesn't define the content block base renders fails too,
+	// naming the page.
+	fsys["templates/page.tmpl.html"] = &fstest.MapFile{Data: []byte(`{{define "contnet"}}typo{{end}}`)}
+	_, err = loadTemplates(testConfig, fsys, &assetManifest{urls: map[string]string{}})
+	if err == nil || !strings.Contains(err.Error(), "page.tmpl.html") || !strings.Contains(err.Error(), `"content"`) {
+		t.Fatalf("expected an error naming the page and the missing block, got %v", err)
+	}
+}
+
+func TestHighlightClasses(t *testing.T) {
+	fsys := fstest.MapFS{"posts/code.md": &fstest.MapFile{Data: []byte("---\ntitle: \"Code\"\npublished: \"Feb 18 2023 PST\"\n---\n\n```go\nfunc main() {}\n```\n")}}
+	p, err := loadPost(testConfig, fsys, "posts/code.md")
+	if err != nil {
+		t.Fatalf("loading post: %v", err)
+	}
+	content := string(p.Content)
+	if strings.Contains(content, "style=") {
+		t.Errorf("highlighted code has inline styles:\n%s", content)
+	}
+	for _, want := range []string{`<pre class="chroma">`, `<span class="kd">func</span>`} {
+		if !strings.Contains(content, want) {
+			t.Errorf("rendered post is missing %q, got:\n%s", want, content)
+		}
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	source := []byte(`---
+title: "Render"
+published: "Feb 18 2023 PST"
+---
+
+## Intro
+
+Some *text*, a [link](https://go.dev) and a footnote[^1].
+
+<script>alert("hi")</script>
+
+<p onclick="alert('hi')">Inline html.</p>
+
+` + "```go\nfunc main() {}\n```\n" + `
+[^1]: The footnote.
+`)
+	first, meta, err := renderMarkdown(source, "morgangallant.com", 3)
+	if err != nil {
+		t.Fatalf("rendering: %v", err)
+	}
+	if meta.Title != "Render" || len(meta.TOC) != 1 || meta.TOC[0].ID != "intro" {
+		t.Errorf("unexpected metadata %+v", meta)
+	}
+	for _, banned := range []string{"<script", "alert", "onclick"} {
+		if strings.Contains(string(first), banned) {
+			t.Errorf("rendered content contains %q:\n%s", banned, first)
+		}
+	}
+	for range 3 {
+		again, _, err := renderMarkdown(source, "morgangallant.com", 3)
+		if err != nil {
+			t.Fatalf("rendering again: %v", err)
+		}
+		if again != first {
+			t.Fatalf("rendering isn't deterministic, got:\n%s\nthen:\n%s", first, again)
+		}
+	}
+	if sanitized := bmPolicy.Sanitize(string(first)); sanitized != string(first) {
+		t.Errorf("sanitizing rendered content again changed it, got:\n%s\nwant:\n%s", sanitized, first)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	p := buildPolicy()
+	for _, allowed := range []string{
+		`<pre class="chroma"><code><span class="kd">func</span></code></pre>`,
+		`<div class="math display">x</div>`,
+		`<div class="footnotes" role="doc-endnotes">x</div>`,
+		`<a href="#fn:1" class="footnote-ref" role="doc-noteref" rel="nofollow">1</a>`,
+		`<a href="#fnref:1" class="footnote-backref" role="doc-backlink" rel="nofollow">x</a>`,
+		`<a href="#intro" class="heading-anchor" rel="nofollow">x</a>`,
+		`<img src="/a.png" loading="lazy" decoding="async">`,
+		`<a href="https://go.dev" target="_blank" rel="noopener noreferrer nofollow">x</a>`,
+		`<td align="right">x</td>`,
+	} {
+		if got := p.Sanitize(allowed); got != allowed {
+			t.Errorf("sanitizing %s = %s, want it unchanged", allowed, got)
+		}
+	}
+	for _, tc := range []struct{ in, want string }{
+		{`<p onclick="alert(1)">x</p>`, `<p>x</p>`},
+		{`<div class="evil">x</div>`, `<div>x</div>`},
+		{`<a href="#x" class="evil" rel="nofollow">x</a>`, `<a href="#x" rel="nofollow">x</a>`},
+		{`<span style="color: red">x</span>`, `<span>x</span>`},
+		{`<pre class="x&quot;y">x</pre>`, `<pre>x</pre>`},
+		{`<img src="/a.png" loading="never">`, `<img src="/a.png">`},
+		{`<a href="https://go.dev" target="_top" rel="nofollow">x</a>`, `<a href="https://go.dev" rel="nofollow">x</a>`},
+		{`<script>alert(1)</script>`, ``},
+	} {
+		if got := p.Sanitize(tc.in); got != tc.want {
+			t.Errorf("sanitizing %s = %s, want %s", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestDateFilter(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		want  dateFilter
+		url   string
+	}{
+		{"", dateFilter{}, "/blog?page=2"},
+		{"year=2024", dateFilter{Year: 2024}, "/blog?page=2&year=2024"},
+		{"year=2024&month=03", dateFilter{Year: 2024, Month: time.March}, "/blog?month=03&page=2&year=2024"},
+		{"year=2024&month=13", dateFilter{Year: 2024}, "/blog?page=2&year=2024"},
+		{"year=abc&month=03", dateFilter{}, "/blog?page=2"},
+		{"month=03", dateFilter{}, "/blog?page=2"},
+	} {
+		r, _ := http.NewRequest(http.MethodGet, "/blog?"+tc.query, nil)
+		got := parseDateFilter(r)
+		if got != tc.want {
+			t.Errorf("parseDateFilter(%q) = %+v, want %+v", tc.query, got, tc.want)
+		}
+		if u := (blogQuery{Filter: got}).URL(2); u != tc.url {
+			t.Errorf("URL(2) of %+v = %q, want %q", got, u, tc.url)
+		}
+	}
+
+	march := dateFilter{Year: 2024, Month: time.March}
+	if s := march.String(); s != "March 2024" {
+		t.Errorf("String() = %q, want %q", s, "March 2024")
+	}
+	if u := (blogQuery{Filter: march}).URL(1); u != "/blog?month=03&year=2024" {
+		t.Errorf("URL(1) = %q", u)
+	}
+	for date, want := range map[time.Time]bool{
+		time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC): true,
+		time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC): false,
+		time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC): false,
+	} {
+		if got := march.match(date); got != want {
+			t.Errorf("match(%s) = %t, want %t", date, got, want)
+		}
+	}
+}
+
+func TestSortPosts(t *testing.T) {
+	posts := []*post{
+		{Slug: "c", Title: "banana"},
+		{Slug: "b", Title: "Apple"},
+		{Slug: "a", Title: "cherry"},
+	}
+	slugs := func(posts []*post) string {
+		var s string
+		for _, p := range posts {
+			s += p.Slug
+		}
+		return s
+	}
+	for sort, want := range map[string]string{
+		"newest": "cba",
+		"oldest": "abc",
+		"title":  "bca",
+	} {
+		if got := slugs(sortPosts(posts, sort)); got != want {
+			t.Errorf("sortPosts(%s) = %s, want %s", sort, got, want)
+		}
+	}
+	if got := slugs(posts); got != "cba" {
+		t.Errorf("sortPosts modified the original slice, now %s", got)
+	}
+
+	for query, want := range map[string]string{
+		"sort=title":            "title",
+		"sort=oldest&year=2024": "oldest",
+		"sort=Title":            "newest",
+		"":                      "newest",
+	} {
+		r, _ := http.NewRequest(http.MethodGet, "/blog?"+query, nil)
+		if got := parseBlogSort(r); got != want {
+			t.Errorf("parseBlogSort(%q) = %s, want %s", query, got, want)
+		}
+	}
+
+	q := blogQuery{Filter: dateFilter{Year: 2024}, Sort: "oldest"}
+	if u := q.URL(2); u != "/blog?page=2&sort=oldest&year=2024" {
+		t.Errorf("URL(2) = %q", u)
+	}
+	if u := q.SortURL("newest"); u != "/blog?year=2024" {
+		t.Errorf("SortURL(newest) = %q", u)
+	}
+}
+
+func TestFeedGUID(t *testing.T) {
+	p := &post{
+		Title:       "Hello",
+		Slug:        "hello",
+		PublishedAt: time.Date(2023, 2, 18, 0, 0, 0, 0, time.FixedZone("PST", -8*60*60)),
+	}
+	const want = `<guid isPermaLink="false">tag:morgangallant.com,2023-02-18:/blog/hello</guid>`
+	for range 2 {
+		feed, err := buildFeed(testConfig, "Blog", testConfig.BaseURL+"/blog", []*post{p})
+		if err != nil {
+			t.Fatalf("building feed: %v", err)
+		}
+		rss, err := toRSS(feed)
+		if err != nil {
+			t.Fatalf("creating rss: %v", err)
+		}
+		if strings.Count(rss, want) != 1 {
+			t.Fatalf("rss is missing %s, got:\n%s", want, rss)
+		}
+		for _, want := range []string{"<channel>", "<item>", "<title>Hello</title>", "<link>https://morgangallant.com/blog/hello</link>"} {
+			if !strings.Contains(rss, want) {
+				t.Errorf("rss is missing %s, got:\n%s", want, rss)
+			}
+		}
+	}
+}
+
+func TestPostAuthor(t *testing.T) {
+	fsys := fstest.MapFS{
+		"posts/own.md": &fstest.MapFile{Data: []byte(`---
+title: "Own"
+published: "Feb 18 2023 PST"
+---
+
+Hello.
+`)},
+		"posts/guest.md": &fstest.MapFile{Data: []byte(`---
+title: "Guest"
+published: "Feb 19 2023 PST"
+author: "Guest Writer"
+author_email: "guest@example.com"
+---
+
+Hello.
+`)},
+	}
+	cfg := *testConfig
+	cfg.AuthorEmail = "morgan@morgangallant.com"
+	posts, err := loadPostsFrom(&cfg, fsys, "posts", 1, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("loading posts: %v", err)
+	}
+	authors := make(map[string][2]string)
+	for _, p := range posts {
+		authors[p.Slug] = [2]string{p.Author, p.AuthorEmail}
+	}
+	if got, want := authors["own"], [2]string{"Morgan Gallant", "morgan@morgangallant.com"}; got != want {
+		t.Errorf("author of own post = %v, want %v", got, want)
+	}
+	if got, want := authors["guest"], [2]string{"Guest Writer", "guest@example.com"}; got != want {
+		t.Errorf("author of guest post = %v, want %v", got, want)
+	}
+
+	feed, err := buildFeed(&cfg, "Blog", cfg.BaseURL+"/blog", posts)
+	if err != nil {
+		t.Fatalf("building feed: %v", err)
+	}
+	rss, err := toRSS(feed)
+	if err != nil {
+		t.Fatalf("creating rss: %v", err)
+	}
+	for _, want := range []string{"<author>Guest Writer</author>", "<author>Morgan Gallant</author>"} {
+		if !strings.Contains(rss, want) {
+			t.Errorf("rss is missing %s, got:\n%s", want, rss)
+		}
+	}
+	jsonFeed, err := buildJSONFeed(&cfg, feed)
+	if err != nil {
+		t.Fatalf("creating json feed: %v", err)
+	}
+	if n := strings.Count(string(jsonFeed), `"authors"`); n != 2 {
+		t.Errorf("expected authors on the json feed and the guest post only, got:\n%s", jsonFeed)
+	}
+}
+
+func TestParsePostDate(t *testing.T) {
+	want := time.Date(2023, time.February, 3, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		layout, value string
+	}{
+		{"Jan 02 2006 MST", "Feb 03 2023 PST"},
+		{"Jan 02 2006 MST", "2023-02-03T00:00:00Z"},
+		{"Jan 02 2006 MST", "2023-02-03T08:00:00+08:00"},
+		{"Jan 02 2006 MST", "2023-02-03"},
+		{"02/01/2006", "03/02/2023"},
+	} {
+		got, err := parsePostDate(tc.layout, tc.value)
+		if err != nil {
+			t.Errorf("parsePostDate(%q, %q): %v", tc.layout, tc.value, err)
+		} else if !got.Equal(want) || got.Location() != time.UTC {
+			t.Errorf("parsePostDate(%q, %q) = %v, want %v", tc.layout, tc.value, got, want)
+		}
+	}
+
+	_, err := parsePostDate("Jan 02 2006 MST", "last tuesday")
+	if err == nil || !strings.Contains(err.Error(), `"Jan 02 2006 MST"`) {
+		t.Errorf("parsing an invalid date = %v, want an error with the expected layout", err)
+	}
+}
+
+func TestSuggestPosts(t *testing.T) {
+	var posts []*post
+	for _, slug := range []string{
+		"building-a-blog-in-go",
+		"go-concurrency-patterns",
+		"hello-world",
+		"why-i-left-amd",
+		"rewriting-the-blog",
+	} {
+		posts = append(posts, &post{Slug: slug})
+	}
+	for slug, want := range map[string]string{
+		"building-a-blog-in-og": "building-a-blog-in-go",
+		"Hello-World":           "hello-world",
+		"hello":                 "hello-world",
+		"concurrency-patterns":  "go-concurrency-patterns",
+		"the-blog":              "rewriting-the-blog",
+		"blog":                  "",
+		"why-i-left-nvidia":     "why-i-left-amd",
+		"helo-world.md":         "hello-world",
+		"recipes":               "",
+		"":                      "",
+	} {
+		var got []string
+		for _, p := range suggestPosts(posts, slug) {
+			got = append(got, p.Slug)
+		}
+		if strings.Join(got, " ") != want {
+			t.Errorf("suggestPosts(%q) = %v, want %s", slug, got, want)
+		}
+	}
+
+	if got := suggestPosts(posts, "hello-world-"+strings.Repeat("x", maxSuggestionSlugLength)); got != nil {
+		t.Errorf("suggestPosts with an overly long slug = %d posts, want none", len(got))
+	}
+
+	for i := range 5 {
+		posts = append(posts, &post{Slug: fmt.Sprintf("hello-world-%d", i)})
+	}
+	if got := suggestPosts(posts, "hello-world"); len(got) != maxSuggestions || got[0].Slug != "hello-world" {
+		t.Errorf("suggestPosts(hello-world) = %d posts starting with %s, want %d starting with hello-world", len(got), got[0].Slug, maxSuggestions)
+	}
+}
+
+func TestTagCloud(t *testing.T) {
+	posts := make([]*post, 9)
+	for i := range posts {
+		posts[i] = &post{Slug: fmt.Sprint(i)}
+	}
+	tagIndex := map[string][]*post{
+		"go":      posts[:9],
+		"web":     posts[:5],
+		"cooking": posts[:1],
+		"art":     posts[:1],
+	}
+	describe := func(tags []tagCount) string {
+		var s []string
+		for _, tc := range tags {
+			s = append(s, fmt.Sprintf("%s:%d:%d", tc.Tag, tc.Count, tc.Weight))
+		}
+		return strings.Join(s, " ")
+	}
+	if got, want := describe(tagCloud(tagIndex, "name")), "art:1:1 cooking:1:1 go:9:5 web:5:3"; got != want {
+		t.Errorf("tags by name = %s, want %s", got, want)
+	}
+	if got, want := describe(tagCloud(tagIndex, "count")), "go:9:5 web:5:3 art:1:1 cooking:1:1"; got != want {
+		t.Errorf("tags by count = %s, want %s", got, want)
+	}
+	if got, want := describe(tagCloud(map[string][]*post{"go": posts[:2], "web": posts[:2]}, "name")), "go:2:1 web:2:1"; got != want {
+		t.Errorf("equally common tags = %s, want %s", got, want)
+	}
+}
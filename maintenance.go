@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/joho/godotenv"
+)
+
+const maintenanceTemplate = "maintenance"
+
+// maintenanceRetryAfter is sent along with the maintenance page, since
+// there's no telling how long it'll be up for.
+const maintenanceRetryAfter = "300"
+
+// maintenanceMode responds to requests with the 503 maintenance page while
+// the site is in maintenance, see Config.Maintenance. The health checks and
+// the assets the page needs are let through, as is anyone in the allowlist.
+func (s *Server) maintenanceMode(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case !s.maintenance.Load(),
+			r.URL.Path == "/healthz",
+			r.URL.Path == "/readyz",
+			s.store.assets.serves(r.URL.Path),
+			isTrusted(clientIP(r), s.cfg.MaintenanceAllow):
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", maintenanceRetryAfter)
+		w.Header().Set("Cache-Control", "no-store")
+		if err := s.store.forRequest(r).render(w, maintenanceTemplate, http.StatusServiceUnavailable, templateData[struct{}]{
+			Subtitle:   "Maintenance",
+			Meta:       pageMeta{NoIndex: true},
+			commonData: s.store.common(r),
+		}); err != nil {
+			s.store.serverError(w, r, err)
+		}
+	})
+}
+
+// reloadMaintenance re-reads MAINTENANCE, see reloadOnHangup. Values in
+// .env take precedence, since the environment of a running process can't
+// be changed from outside.
+func (s *Server) reloadMaintenance() {
+	raw := os.Getenv("MAINTENANCE")
+	if env, err := godotenv.Read(); err == nil {
+		if v, ok := env["MAINTENANCE"]; ok {
+			raw = v
+		}
+	}
+	on := false
+	if raw != "" {
+		var err error
+		if on, err = strconv.ParseBool(raw); err != nil {
+			s.logger.Error("parsing MAINTENANCE, leaving it unchanged", slog.String("error", err.Error()))
+			return
+		}
+	}
+	if s.maintenance.Swap(on) != on {
+		s.logger.Info("toggled maintenance mode", slog.Bool("maintenance", on))
+	}
+}
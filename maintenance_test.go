@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	cfg := *testConfig
+	cfg.Maintenance = true
+	cfg.MaintenanceAllow = []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")}
+	srv := newTestServer(t, &cfg, testFS())
+	handler := srv.Handler()
+	styles, err := srv.store.assets.url("styles.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path, client string
+		status       int
+	}{
+		{"/", "192.0.2.1", http.StatusServiceUnavailable},
+		{"/blog/first-post", "192.0.2.1", http.StatusServiceUnavailable},
+		{"/feed", "192.0.2.1", http.StatusServiceUnavailable},
+		{"/healthz", "192.0.2.1", http.StatusOK},
+		{"/readyz", "192.0.2.1", http.StatusOK},
+		{styles, "192.0.2.1", http.StatusOK},
+		{"/", "203.0.113.7", http.StatusOK},
+		{"/blog/first-post", "203.0.113.7", http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		r.RemoteAddr = tc.client + ":1234"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("GET %s from %s = %d, want %d", tc.path, tc.client, w.Code, tc.status)
+			continue
+		}
+		if w.Code != http.StatusServiceUnavailable {
+			continue
+		}
+		if !strings.Contains(w.Body.String(), "Maintenance") {
+			t.Errorf("GET %s didn't render the maintenance page: %s", tc.path, w.Body)
+		}
+		if w.Header().Get("Retry-After") == "" {
+			t.Errorf("GET %s is missing Retry-After", tc.path)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	var health struct {
+		Status      string `json:"status"`
+		Maintenance bool   `json:"maintenance"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&health); err != nil {
+		t.Fatalf("decoding health: %v", err)
+	}
+	if health.Status != "ok" || !health.Maintenance {
+		t.Errorf("health = %+v, want ok and in maintenance", health)
+	}
+
+	srv.maintenance.Store(false)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GET / after leaving maintenance = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestReloadMaintenance(t *testing.T) {
+	srv := newTestServer(t, testConfig, testFS())
+	for _, tc := range []struct {
+		env  string
+		want bool
+	}{
+		{"true", true},
+		{"nope", true},
+		{"0", false},
+		{"", false},
+	} {
+		t.Setenv("MAINTENANCE", tc.env)
+		srv.reloadMaintenance()
+		if got := srv.maintenance.Load(); got != tc.want {
+			t.Errorf("maintenance after reloading MAINTENANCE=%q = %t, want %t", tc.env, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// mathExtension parses $inline$ and $$display$$ math, and renders it with
+// the \(...\) and \[...\] delimiters which both KaTeX's auto-render and
+// MathJax pick up. The TeX itself is passed through untouched, so things
+// like underscores aren't mistaken for emphasis.
+type mathExtension struct{}
+
+func (mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(mathBlockParser{}, 700)),
+		parser.WithInlineParsers(util.Prioritized(mathInlineParser{}, 500)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(mathRenderer{}, 500)))
+}
+
+var (
+	kindMathInline = ast.NewNodeKind("MathInline")
+	kindMathBlock  = ast.NewNodeKind("MathBlock")
+)
+
+// mathInline is math within a paragraph, display is set for $$...$$.
+type mathInline struct {
+	ast.BaseInline
+	display bool
+}
+
+func (n *mathInline) Kind() ast.NodeKind { return kindMathInline }
+
+func (n *mathInline) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// mathBlock is display math between $$ lines. A block opened and closed on
+// the same line is marked as closed straight away.
+type mathBlock struct {
+	ast.BaseBlock
+	closed bool
+}
+
+func (n *mathBlock) Kind() ast.NodeKind { return kindMathBlock }
+
+func (n *mathBlock) IsRaw() bool { return true }
+
+func (n *mathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+type mathInlineParser struct{}
+
+func (mathInlineParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+// Parse follows the usual rules for telling math apart from prices: the
+// opening $ can't be followed by a space, and the closing $ can't be
+// preceded by a space or followed by a digit. Math can't span lines.
+func (mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+	opener := 0
+	for opener < len(line) && line[opener] == '$' {
+		opener++
+	}
+	if opener > 2 || opener >= len(line) || util.IsSpace(line[opener]) {
+		return nil
+	}
+	for i := opener; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+			continue
+		case '$':
+		default:
+			continue
+		}
+		j := i
+		for j < len(line) && line[j] == '$' {
+			j++
+		}
+		if j-i == opener && !util.IsSpace(line[i-1]) && (j == len(line) || !util.IsNumeric(line[j])) {
+			node := &mathInline{display: opener == 2}
+			node.AppendChild(node, ast.NewRawTextSegment(text.NewSegment(segment.Start+opener, segment.Start+i)))
+			block.Advance(j)
+			return node
+		}
+		i = j - 1
+	}
+	return nil
+}
+
+type mathBlockParser struct{}
+
+func (mathBlockParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], []byte("$$")) {
+		return nil, parser.NoChildren
+	}
+	node := &mathBlock{}
+	start := segment.Start + pos + 2
+	rest := util.TrimRightSpace(line[pos+2:])
+	if len(rest) >= 2 && bytes.HasSuffix(rest, []byte("$$")) {
+		node.closed = true
+		rest = rest[:len(rest)-2]
+	}
+	if !util.IsBlank(rest) {
+		node.Lines().Append(text.NewSegment(start, start+len(rest)))
+	}
+	reader.Advance(advanceLine(line, segment))
+	return node, parser.NoChildren
+}
+
+func (mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	if node.(*mathBlock).closed {
+		return parser.Close
+	}
+	line, segment := reader.PeekLine()
+	trimmed := util.TrimRightSpace(line)
+	if bytes.HasSuffix(trimmed, []byte("$$")) {
+		if content := trimmed[:len(trimmed)-2]; !util.IsBlank(content) {
+			node.Lines().Append(text.NewSegment(segment.Start, segment.Start+len(content)))
+		}
+		reader.Advance(advanceLine(line, segment))
+		return parser.Close
+	}
+	node.Lines().Append(segment)
+	reader.Advance(advanceLine(line, segment))
+	return parser.Continue | parser.NoChildren
+}
+
+func (mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (mathBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (mathBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// advanceLine is how far to advance the reader to consume the rest of line,
+// leaving the trailing newline (if any) for the parser.
+func advanceLine(line []byte, segment text.Segment) int {
+	n := segment.Len()
+	if len(line) > 0 && line[len(line)-1] == '\n' {
+		n--
+	}
+	return n
+}
+
+type mathRenderer struct{}
+
+func (r mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMathInline, r.renderInline)
+	reg.Register(kindMathBlock, r.renderBlock)
+}
+
+func (mathRenderer) renderInline(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	class, opening, closing := "math inline", `\(`, `\)`
+	if n.(*mathInline).display {
+		class, opening, closing = "math display", `\[`, `\]`
+	}
+	_, _ = w.WriteString(`<span class="` + class + `">` + opening)
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		_, _ = w.Write(util.EscapeHTML(c.(*ast.Text).Segment.Value(source)))
+	}
+	_, _ = w.WriteString(closing + "</span>")
+	return ast.WalkSkipChildren, nil
+}
+
+func (mathRenderer) renderBlock(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	_, _ = w.WriteString(`<div class="math display">\[`)
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		_, _ = w.Write(util.EscapeHTML(line.Value(source)))
+	}
+	_, _ = w.WriteString("\\]</div>\n")
+	return ast.WalkSkipChildren, nil
+}
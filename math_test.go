@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMath(t *testing.T) {
+	fsys := fstest.MapFS{"posts/math.md": &fstest.MapFile{Data: []byte(`---
+title: "Math"
+published: "Feb 18 2023 PST"
+---
+
+Euler's identity $e^{i\pi} + 1 = 0$ is neat, and so is $$a_1 * b_1$$ inline.
+
+$$
+\sum_{i=1}^n i = \frac{n(n+1)}{2}
+$$
+
+$$x < y$$
+
+It costs $5 or $10, and \$x\$ stays literal.
+`)}}
+	p, err := loadPost(testConfig, fsys, "posts/math.md")
+	if err != nil {
+		t.Fatalf("loading post: %v", err)
+	}
+	content := string(p.Content)
+	for _, want := range []string{
+		`<span class="math inline">\(e^{i\pi} + 1 = 0\)</span>`,
+		`<span class="math display">\[a_1 * b_1\]</span>`,
+		"<div class=\"math display\">\\[\\sum_{i=1}^n i = \\frac{n(n+1)}{2}\n\\]</div>",
+		`<div class="math display">\[x &lt; y\]</div>`,
+		"It costs $5 or $10, and $x$ stays literal.",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("rendered post is missing %q, got:\n%s", want, content)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the request latency histogram in
+// seconds, the same as the Prometheus client's defaults.
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metrics counts requests and their latencies, and serves them in the
+// Prometheus text format. It's small enough not to warrant pulling in the
+// Prometheus client. A nil *metrics ignores everything, so it doesn't need
+// to be checked for when metrics are disabled.
+type metrics struct {
+	// route labels a request with the pattern it matched, rather than its
+	// path, so that requests for random urls don't create new series.
+	route func(*http.Request) string
+
+	mu       sync.Mutex
+	requests map[requestLabels]uint64
+	buckets  []uint64 // Not cumulative, one more than latencyBuckets for +Inf.
+	sum      float64
+	count    uint64
+}
+
+type requestLabels struct {
+	path   string
+	status int
+}
+
+func newMetrics(mux *http.ServeMux) *metrics {
+	return &metrics{
+		route: func(r *http.Request) string {
+			_, pattern := mux.Handler(r)
+			// Drop the method, it's the same for all of the routes.
+			if _, path, ok := strings.Cut(pattern, " "); ok {
+				return path
+			}
+			return pattern
+		},
+		requests: make(map[requestLabels]uint64),
+		buckets:  make([]uint64, len(latencyBuckets)+1),
+	}
+}
+
+func (m *metrics) observe(r *http.Request, status int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	labels := requestLabels{path: m.route(r), status: status}
+	seconds := d.Seconds()
+	i, _ := slices.BinarySearch(latencyBuckets, seconds)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[labels]++
+	m.buckets[i]++
+	m.sum += seconds
+	m.count++
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	labels := make([]requestLabels, 0, len(m.requests))
+	for l := range m.requests {
+		labels = append(labels, l)
+	}
+	slices.SortFunc(labels, func(a, b requestLabels) int {
+		return cmp.Or(strings.Compare(a.path, b.path), cmp.Compare(a.status, b.status))
+	})
+
+	var b strings.Builder
+	b.WriteString("# HELP http_requests_total Requests handled, by route and status.\n")
+	b.WriteString("# TYPE http_requests_total counter\n")
+	for _, l := range labels {
+		fmt.Fprintf(&b, "http_requests_total{path=\"%s\",status=\"%d\"} %d\n", labelEscaper.Replace(l.path), l.status, m.requests[l])
+	}
+	b.WriteString("# HELP http_request_duration_seconds How long requests took to handle.\n")
+	b.WriteString("# TYPE http_request_duration_seconds histogram\n")
+	var cumulative uint64
+	for i, n := range m.buckets {
+		cumulative += n
+		le := "+Inf"
+		if i < len(latencyBuckets) {
+			le = strconv.FormatFloat(latencyBuckets[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(&b, "http_request_duration_seconds_bucket{le=\"%s\"} %d\n", le, cumulative)
+	}
+	fmt.Fprintf(&b, "http_request_duration_seconds_sum %s\n", strconv.FormatFloat(m.sum, 'g', -1, 64))
+	fmt.Fprintf(&b, "http_request_duration_seconds_count %d\n", m.count)
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write([]byte(b.String()))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	cfg := *testConfig
+	cfg.MetricsEnabled = true
+	srv := newTestServer(t, &cfg, testFS())
+	for _, path := range []string{"/blog/first-post", "/blog/second-post", "/blog/missing", "/random", "/random/other"} {
+		srv.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /metrics = %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`http_requests_total{path="/",status="404"} 2`,
+		`http_requests_total{path="/blog/{slug}",status="200"} 2`,
+		`http_requests_total{path="/blog/{slug}",status="404"} 1`,
+		"# TYPE http_request_duration_seconds histogram\n",
+		`http_request_duration_seconds_bucket{le="+Inf"} 5`,
+		"http_request_duration_seconds_count 5\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics are missing %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	srv := newTestServer(t, testConfig, testFS())
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /metrics with metrics disabled = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"cmp"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statusRecorder captures the status code and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.size += n
+	return n, err
+}
+
+// securityHeaders sets baseline security headers on every response. HSTS
+// is only sent in production, since dev is served over plain http.
+func securityHeaders(cfg *Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		if cfg.ContentSecurityPolicy != "" {
+			h.Set("Content-Security-Policy", cfg.ContentSecurityPolicy)
+		}
+		if production() {
+			h.Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+		}
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		h.Set("X-Frame-Options", "DENY")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cors lets the sites in cfg.AllowedOrigins fetch the api and feeds from
+// the browser, answering preflight requests for them. Everything else stays
+// same-origin only, as does everything if no origins are allowed.
+func cors(cfg *Config, next http.Handler) http.Handler {
+	if len(cfg.AllowedOrigins) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !corsPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && (slices.Contains(cfg.AllowedOrigins, "*") || slices.Contains(cfg.AllowedOrigins, origin))
+		if !allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if slices.Contains(cfg.AllowedOrigins, "*") {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				h.Set("Access-Control-Allow-Headers", headers)
+			}
+			h.Set("Access-Control-Max-Age", "86400")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", "ETag, Last-Modified")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsPath reports whether path is one of the api or feed routes, which
+// are the only ones cors applies to.
+func corsPath(path string) bool {
+	return path == "/api" || strings.HasPrefix(path, "/api/") ||
+		strings.HasPrefix(path, "/feed") ||
+		path == "/atom.xml" ||
+		(strings.HasPrefix(path, "/blog/tag/") && strings.HasSuffix(path, "/feed.xml"))
+}
+
+// requestTimeout cancels the context of each request after timeout, so
+// handlers doing i/o, like fetching the source of a webmention, stop
+// waiting once nobody will see the response anyway.
+func requestTimeout(timeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// limitBody caps the size of request bodies at cfg.MaxBodySize, so a client
+// can't make a handler read an arbitrary amount into memory. Requests which
+// say up front that their body is too large are rejected straight away,
+// otherwise handlers see an *http.MaxBytesError once they read past the
+// limit, see parseForm.
+func limitBody(cfg *Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength > cfg.MaxBodySize {
+			w.Header().Set("Connection", "close")
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxBodySize)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// parseForm parses the form in the body of r, responding with a 413 if the
+// body is too large or a 400 if it's malformed, in which case it returns
+// false. Unlike PostFormValue, errors aren't ignored, so a truncated form
+// can't be mistaken for one missing some fields.
+func parseForm(w http.ResponseWriter, r *http.Request) bool {
+	err := r.ParseForm()
+	if err == nil {
+		return true
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+	} else {
+		http.Error(w, "malformed form", http.StatusBadRequest)
+	}
+	return false
+}
+
+// trimTrailingSlash permanently redirects GET and HEAD requests for a path
+// ending in a slash to the same path without it, so every page has a single
+// url. Leading slashes are collapsed too, so that //example.com/ doesn't
+// turn into a protocol-relative redirect.
+func trimTrailingSlash(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" || !strings.HasSuffix(r.URL.Path, "/") ||
+			(r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		to := "/" + strings.Trim(r.URL.Path, "/")
+		if r.URL.RawQuery != "" {
+			to += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, to, http.StatusMovedPermanently)
+	})
+}
+
+// recoverPanics stops a panicking handler from taking down the server. The
+// panic is logged along with its stack trace, and the 500 page is rendered
+// if nothing has been written to the response yet.
+func (s *contentStore) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sr := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			} else if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			s.logger.Error(
+				"recovered from panic",
+				slog.String("path", r.URL.Path),
+				slog.Any("panic", v),
+				slog.String("stack", string(debug.Stack())),
+			)
+			if sr.status != 0 {
+				// Too late to send an error page, so just cut the response
+				// short rather than let the client think it's complete.
+				panic(http.ErrAbortHandler)
+			}
+			s.renderServerError(w, r, fmt.Errorf("panic: %v", v))
+		}()
+		next.ServeHTTP(sr, r)
+	})
+}
+
+// headRequests makes HEAD responses carry the same headers as GET ones.
+// The mux already routes HEAD to the GET handlers, but net/http only sets
+// Content-Length for small bodies, so the body is counted and discarded
+// here instead, and the headers are held back until the handler returns.
+func headRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		hw := &headWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(hw, r)
+		h := w.Header()
+		bodyAllowed := hw.status >= 200 && hw.status != http.StatusNoContent && hw.status != http.StatusNotModified
+		if bodyAllowed && h.Get("Content-Length") == "" && h.Get("Transfer-Encoding") == "" {
+			h.Set("Content-Length", strconv.Itoa(hw.size))
+		}
+		w.WriteHeader(hw.status)
+	})
+}
+
+// headWriter discards the body of a response, keeping only its status and
+// length.
+type headWriter struct {
+	http.ResponseWriter
+	status  int
+	size    int
+	written bool
+}
+
+func (hw *headWriter) WriteHeader(status int) {
+	if hw.written {
+		return
+	}
+	hw.status = status
+	hw.written = status >= 200
+}
+
+func (hw *headWriter) Write(b []byte) (int, error) {
+	hw.written = true
+	hw.size += len(b)
+	return len(b), nil
+}
+
+// logRequests logs every request once it has been handled, and records it in
+// m. Health checks are polled frequently, so those are only logged at debug
+// level.
+func logRequests(logger *slog.Logger, m *metrics, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sr, r)
+		status, duration := cmp.Or(sr.status, http.StatusOK), time.Since(start)
+		m.observe(r, status, duration)
+
+		level := slog.LevelInfo
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			level = slog.LevelDebug
+		}
+		logger.LogAttrs(
+			r.Context(),
+			level,
+			"handled request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("client", clientIP(r)),
+			slog.Int("status", status),
+			slog.Int("size", sr.size),
+			slog.Duration("duration", duration),
+		)
+	})
+}
+
+// compressMinSize is the smallest response body which is worth compressing,
+// anything shorter is sent as-is.
+const compressMinSize = 1024
+
+// compress negotiates Accept-Encoding with the client and gzip (or deflate)
+// encodes responses which are large enough and not already compressed.
+func compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, status: http.StatusOK}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header,
+// preferring gzip, and returns an empty string if neither is acceptable.
+func negotiateEncoding(header string) string {
+	accepted := make(map[string]bool)
+	for _, e := range parseAccept(header) {
+		accepted[e.value] = e.q > 0
+	}
+	for _, encoding := range []string{"gzip", "deflate"} {
+		if accepted[encoding] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// negotiateMediaType picks the offer the Accept header prefers, with ties
+// going to the earlier offer. Wildcards like text/* and */* are supported.
+// The first offer is returned if the header is empty or accepts none of
+// them, since a response in some format beats none at all.
+func negotiateMediaType(header string, offers ...string) string {
+	if strings.TrimSpace(header) == "" {
+		return offers[0]
+	}
+	accepted := parseAccept(header)
+	best, bestQ := offers[0], 0.0
+	for _, offer := range offers {
+		// The most specific range which matches the offer decides its q.
+		q, specificity := 0.0, -1
+		for _, e := range accepted {
+			var s int
+			switch {
+			case e.value == offer:
+				s = 2
+			case strings.HasSuffix(e.value, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(e.value, "*")):
+				s = 1
+			case e.value == "*/*":
+				s = 0
+			default:
+				continue
+			}
+			if s > specificity {
+				q, specificity = e.q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+type acceptEntry struct {
+	value string
+	q     float64
+}
+
+// parseAccept splits an Accept or Accept-Encoding header into its values,
+// lowercased and without parameters, along with their q-values.
+func parseAccept(header string) []acceptEntry {
+	var entries []acceptEntry
+	for _, part := range strings.Split(header, ",") {
+		value, params, _ := strings.Cut(part, ";")
+		e := acceptEntry{value: strings.ToLower(strings.TrimSpace(value)), q: 1}
+		if e.value == "" {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					e.q = parsed
+				}
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// compressible reports whether responses of the given content type benefit
+// from compression, i.e. images and archives are already compressed.
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasSuffix(mediaType, "+xml"), strings.HasSuffix(mediaType, "+json"):
+		return true
+	case mediaType == "image/svg+xml":
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/manifest+json":
+		return true
+	}
+	return false
+}
+
+// compressWriter buffers the start of a response until it knows whether the
+// body is large enough to be compressed, then either sets up an encoder or
+// passes everything through untouched.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+
+	buf     []byte
+	decided bool
+	enc     io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided {
+		return
+	}
+	cw.status = status
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < compressMinSize {
+			return len(b), nil
+		}
+		if err := cw.decide(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *compressWriter) decide() error {
+	cw.decided = true
+
+	h := cw.ResponseWriter.Header()
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	if len(cw.buf) >= compressMinSize &&
+		cw.status == http.StatusOK &&
+		h.Get("Content-Encoding") == "" &&
+		compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		// A strong ETag promises the exact bytes, which the encoding changes,
+		// so it's weakened. If-None-Match still matches it either way.
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		switch cw.encoding {
+		case "gzip":
+			cw.enc = gzip.NewWriter(cw.ResponseWriter)
+		case "deflate":
+			enc, err := flate.NewWriter(cw.ResponseWriter, flate.DefaultCompression)
+			if err != nil {
+				return err
+			}
+			cw.enc = enc
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if cw.enc != nil {
+		_, err := cw.enc.Write(buf)
+		return err
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		_ = cw.decide()
+	}
+	if cw.enc != nil {
+		_ = cw.enc.Close()
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrimTrailingSlash(t *testing.T) {
+	srv := newTestServer(t, testConfig, testFS())
+	for _, tc := range []struct {
+		method, path string
+		status       int
+		location     string
+	}{
+		{http.MethodGet, "/blog/", http.StatusMovedPermanently, "/blog"},
+		{http.MethodGet, "/uses/", http.StatusMovedPermanently, "/uses"},
+		{http.MethodHead, "/blog/first-post/", http.StatusMovedPermanently, "/blog/first-post"},
+		{http.MethodGet, "/blog/?page=2", http.StatusMovedPermanently, "/blog?page=2"},
+		{http.MethodGet, "/blog//", http.StatusMovedPermanently, "/blog"},
+		{http.MethodGet, "//example.com/", http.StatusMovedPermanently, "/example.com"},
+		{http.MethodGet, "/", http.StatusOK, ""},
+		{http.MethodGet, "/styles.css", http.StatusOK, ""},
+		{http.MethodPost, "/theme/", http.StatusNotFound, ""},
+	} {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		if rec.Code != tc.status {
+			t.Errorf("%s %s = %d, want %d", tc.method, tc.path, rec.Code, tc.status)
+		}
+		if got := rec.Header().Get("Location"); got != tc.location {
+			t.Errorf("%s %s redirected to %q, want %q", tc.method, tc.path, got, tc.location)
+		}
+	}
+}
+
+func TestCompressWeakensETag(t *testing.T) {
+	body := strings.Repeat("body { color: red; }\n", 100)
+	h := compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css; charset=utf-8")
+		w.Header().Set("ETag", `"abc123"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+	}))
+	for _, tc := range []struct {
+		acceptEncoding, etag string
+	}{
+		{"", `"abc123"`},
+		{"gzip", `W/"abc123"`},
+		{"deflate", `W/"abc123"`},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/styles.css", nil)
+		r.Header.Set("Accept-Encoding", tc.acceptEncoding)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if got := rec.Header().Get("ETag"); got != tc.etag {
+			t.Errorf("ETag with Accept-Encoding %q = %s, want %s", tc.acceptEncoding, got, tc.etag)
+		}
+
+		r.Header.Set("If-None-Match", tc.etag)
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != http.StatusNotModified {
+			t.Errorf("revalidating %s with Accept-Encoding %q = %d, want %d", tc.etag, tc.acceptEncoding, rec.Code, http.StatusNotModified)
+		}
+	}
+}
+
+func TestNegotiateMediaType(t *testing.T) {
+	offers := []string{"application/rss+xml", "application/atom+xml", "application/feed+json"}
+	for _, tc := range []struct {
+		accept, want string
+	}{
+		{"", "application/rss+xml"},
+		{"*/*", "application/rss+xml"},
+		{"application/atom+xml", "application/atom+xml"},
+		{"application/feed+json, application/rss+xml;q=0.5", "application/feed+json"},
+		{"application/rss+xml;q=0.5, application/atom+xml;q=0.9", "application/atom+xml"},
+		{"application/*;q=0.5, application/feed+json", "application/feed+json"},
+		{"application/*, application/rss+xml;q=0", "application/atom+xml"},
+		{"text/html", "application/rss+xml"},
+		{"Application/Atom+XML;charset=utf-8;q=0.8, */*;q=0.1", "application/atom+xml"},
+	} {
+		if got := negotiateMediaType(tc.accept, offers...); got != tc.want {
+			t.Errorf("negotiateMediaType(%q) = %s, want %s", tc.accept, got, tc.want)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	cfg := *testConfig
+	cfg.AllowedOrigins = []string{"https://reader.example"}
+	srv := newTestServer(t, &cfg, testFS())
+
+	preflight := func(path, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, path, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		req.Header.Set("Access-Control-Request-Headers", "If-None-Match")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	for _, path := range []string{"/api/posts", "/feed.json", "/feed", "/atom.xml", "/blog/tag/go/feed.xml"} {
+		rec := preflight(path, "https://reader.example")
+		h := rec.Header()
+		if rec.Code != http.StatusNoContent ||
+			h.Get("Access-Control-Allow-Origin") != "https://reader.example" ||
+			h.Get("Access-Control-Allow-Methods") != "GET, HEAD, OPTIONS" ||
+			h.Get("Access-Control-Allow-Headers") != "If-None-Match" {
+			t.Errorf("preflight for %s = %d with headers %v", path, rec.Code, h)
+		}
+	}
+	for _, tc := range []struct{ path, origin string }{
+		{"/api/posts", "https://elsewhere.example"},
+		{"/blog", "https://reader.example"},
+	} {
+		if rec := preflight(tc.path, tc.origin); rec.Code == http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("preflight for %s from %s = %d, want it to be refused", tc.path, tc.origin, rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/feed.xml", nil)
+	req.Header.Set("Origin", "https://reader.example")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "https://reader.example" {
+		t.Errorf("GET /feed.xml from an allowed origin = %d with headers %v", rec.Code, rec.Header())
+	}
+
+	// Without any allowed origins, nothing is shared.
+	req = httptest.NewRequest(http.MethodGet, "/api/posts", nil)
+	req.Header.Set("Origin", "https://reader.example")
+	rec = httptest.NewRecorder()
+	newTestServer(t, testConfig, testFS()).Handler().ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Access-Control-Allow-Origin = %q by default", got)
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	srv := newTestServer(t, testConfig, testFS())
+	for _, tc := range []struct {
+		name   string
+		body   io.Reader
+		status int
+	}{
+		{"small form", strings.NewReader("theme=dark"), http.StatusSeeOther},
+		{"large form", strings.NewReader("theme=dark&padding=" + strings.Repeat("x", 64<<10)), http.StatusRequestEntityTooLarge},
+		// Without a Content-Length, the limit is only hit while reading.
+		{"large streamed form", io.MultiReader(strings.NewReader("theme=dark&padding="), strings.NewReader(strings.Repeat("x", 64<<10))), http.StatusRequestEntityTooLarge},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/theme", tc.body)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s: POST /theme = %d, want %d", tc.name, rec.Code, tc.status)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/blog", strings.NewReader(strings.Repeat("x", 128<<10))))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /blog with a large body = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	done := make(chan error, 1)
+	h := requestTimeout(10*time.Millisecond, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			done <- r.Context().Err()
+		case <-time.After(time.Second):
+			done <- nil
+		}
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("handler's context ended with %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestHeadRequests(t *testing.T) {
+	srv := newTestServer(t, testConfig, testFS())
+	for _, path := range []string{"/blog/first-post", "/feed.xml", "/atom.xml", "/feed.json", "/sitemap.xml", "/blog/tag/go/feed.xml"} {
+		for _, encoding := range []string{"", "gzip"} {
+			do := func(method string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(method, path, nil)
+				req.Header.Set("Accept-Encoding", encoding)
+				rec := httptest.NewRecorder()
+				srv.Handler().ServeHTTP(rec, req)
+				return rec
+			}
+			get, head := do(http.MethodGet), do(http.MethodHead)
+			if head.Code != get.Code || head.Body.Len() != 0 {
+				t.Errorf("HEAD %s with Accept-Encoding %q = %d with a %d byte body, want %d and none", path, encoding, head.Code, head.Body.Len(), get.Code)
+			}
+			if want := strconv.Itoa(get.Body.Len()); head.Header().Get("Content-Length") != want {
+				t.Errorf("HEAD %s with Accept-Encoding %q has Content-Length %q, want %s", path, encoding, head.Header().Get("Content-Length"), want)
+			}
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// The size of generated OpenGraph images, which is what most sites showing
+// link previews expect.
+const (
+	ogWidth  = 1200
+	ogHeight = 630
+	// ogMargin is the space around the text.
+	ogMargin = 80
+)
+
+var (
+	ogBackground = color.RGBA{0x11, 0x11, 0x11, 0xff}
+	ogAccent     = color.RGBA{0xf5, 0xa6, 0x23, 0xff}
+	ogText       = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	ogMuted      = color.RGBA{0x99, 0x99, 0x99, 0xff}
+)
+
+// ogImages generates the OpenGraph images of posts, which show their title,
+// and keeps them around since they only change along with the title.
+type ogImages struct {
+	footer string
+
+	mu    sync.Mutex
+	cache map[string]ogImage
+}
+
+type ogImage struct {
+	title string
+	png   []byte
+	etag  string
+}
+
+func newOGImages(cfg *Config) *ogImages {
+	return &ogImages{
+		footer: strings.TrimPrefix(strings.TrimPrefix(cfg.BaseURL, "https://"), "http://"),
+		cache:  make(map[string]ogImage),
+	}
+}
+
+// get returns the image for p, generating it if p is new or its title has
+// changed since.
+func (o *ogImages) get(p *post) (ogImage, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if img, ok := o.cache[p.Slug]; ok && img.title == p.Title {
+		return img, nil
+	}
+	b, err := renderOGImage(p.Title, o.footer)
+	if err != nil {
+		return ogImage{}, err
+	}
+	img := ogImage{title: p.Title, png: b, etag: etag(b)}
+	o.cache[p.Slug] = img
+	return img, nil
+}
+
+// renderOGImage draws title onto the branded background, wrapped onto as
+// many lines as fit, with footer underneath.
+func renderOGImage(title, footer string) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, ogWidth, ogHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(ogBackground), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, 16, ogHeight), image.NewUniform(ogAccent), image.Point{}, draw.Src)
+
+	const titleScale, footerScale = 7, 4
+	maxChars := (ogWidth - 2*ogMargin) / (glyphAdvance * titleScale)
+	lineHeight := (glyphHeight + 2) * titleScale
+	maxLines := (ogHeight - 2*ogMargin - lineHeight) / lineHeight
+	for i, line := range wrapText(title, maxChars, maxLines) {
+		drawText(img, line, ogMargin, ogMargin+i*lineHeight, titleScale, ogText)
+	}
+	drawText(img, footer, ogMargin, ogHeight-ogMargin-glyphHeight*footerScale, footerScale, ogMuted)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// wrapText splits s into lines of at most width characters, breaking
+// between words where possible. If it takes more than maxLines, the last
+// line is cut short with an ellipsis.
+func wrapText(s string, width, maxLines int) []string {
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(s) {
+		for utf8.RuneCountInString(word) > width {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			cut := len(string([]rune(word)[:width]))
+			lines = append(lines, word[:cut])
+			word = word[cut:]
+		}
+		switch {
+		case line == "":
+			line = word
+		case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	if len(lines) > maxLines {
+		last := []rune(lines[maxLines-1])
+		lines = append(lines[:maxLines-1], string(last[:min(len(last), width-3)])+"...")
+	}
+	return lines
+}
+
+// drawText draws s with its top left corner at x, y, with every pixel of
+// the font scaled up to a square of scale pixels.
+func drawText(img draw.Image, s string, x, y, scale int, c color.Color) {
+	fill := image.NewUniform(c)
+	for _, r := range s {
+		cols := glyph(r)
+		for col, bits := range cols {
+			for row := range glyphHeight {
+				if bits&(1<<row) == 0 {
+					continue
+				}
+				px, py := x+col*scale, y+row*scale
+				draw.Draw(img, image.Rect(px, py, px+scale, py+scale), fill, image.Point{}, draw.Src)
+			}
+		}
+		x += glyphAdvance * scale
+	}
+}
+
+// serve responds with the image of p.
+func (o *ogImages) serve(w http.ResponseWriter, r *http.Request, p *post) {
+	img, err := o.get(p)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", publicCacheControl)
+	w.Header().Set("ETag", img.etag)
+	http.ServeContent(w, r, "", p.LastModified(), bytes.NewReader(img.png))
+}
+
+// The font is a classic 5x7 pixel one, with an extra row for descenders,
+// good enough for titles without having to pull in a font renderer. Each
+// glyph is 5 columns, with the top row in the lowest bit.
+const (
+	glyphHeight  = 8
+	glyphAdvance = 6 // Columns, including the space between glyphs.
+)
+
+// glyph returns the columns of r, with a few common typographic characters
+// mapped to their closest ascii ones and anything else shown as '?'.
+func glyph(r rune) [5]byte {
+	switch r {
+	case '‘', '’':
+		r = '\''
+	case '“', '”':
+		r = '"'
+	case '–', '—':
+		r = '-'
+	}
+	if r < ' ' || r > '~' {
+		r = '?'
+	}
+	return font5x7[r-' ']
+}
+
+var font5x7 = [...][5]byte{
+	{0x00, 0x00, 0x00, 0x00, 0x00}, // ' '
+	{0x00, 0x00, 0x5f, 0x00, 0x00}, // !
+	{0x00, 0x07, 0x00, 0x07, 0x00}, // "
+	{0x14, 0x7f, 0x14, 0x7f, 0x14}, // #
+	{0x24, 0x2a, 0x7f, 0x2a, 0x12}, // $
+	{0x23, 0x13, 0x08, 0x64, 0x62}, // %
+	{0x36, 0x49, 0x55, 0x22, 0x50}, // &
+	{0x00, 0x05, 0x03, 0x00, 0x00}, // '
+	{0x00, 0x1c, 0x22, 0x41, 0x00}, // (
+	{0x00, 0x41, 0x22, 0x1c, 0x00}, // )
+	{0x08, 0x2a, 0x1c, 0x2a, 0x08}, // *
+	{0x08, 0x08, 0x3e, 0x08, 0x08}, // +
+	{0x00, 0x50, 0x30, 0x00, 0x00}, // ,
+	{0x08, 0x08, 0x08, 0x08, 0x08}, // -
+	{0x00, 0x60, 0x60, 0x00, 0x00}, // .
+	{0x20, 0x10, 0x08, 0x04, 0x02}, // /
+	{0x3e, 0x51, 0x49, 0x45, 0x3e}, // 0
+	{0x00, 0x42, 0x7f, 0x40, 0x00}, // 1
+	{0x42, 0x61, 0x51, 0x49, 0x46}, // 2
+	{0x21, 0x41, 0x45, 0x4b, 0x31}, // 3
+	{0x18, 0x14, 0x12, 0x7f, 0x10}, // 4
+	{0x27, 0x45, 0x45, 0x45, 0x39}, // 5
+	{0x3c, 0x4a, 0x49, 0x49, 0x30}, // 6
+	{0x01, 0x71, 0x09, 0x05, 0x03}, // 7
+	{0x36, 0x49, 0x49, 0x49, 0x36}, // 8
+	{0x06, 0x49, 0x49, 0x29, 0x1e}, // 9
+	{0x00, 0x36, 0x36, 0x00, 0x00}, // :
+	{0x00, 0x56, 0x36, 0x00, 0x00}, // ;
+	{0x08, 0x14, 0x22, 0x41, 0x00}, // <
+	{0x14, 0x14, 0x14, 0x14, 0x14}, // =
+	{0x00, 0x41, 0x22, 0x14, 0x08}, // >
+	{0x02, 0x01, 0x51, 0x09, 0x06}, // ?
+	{0x32, 0x49, 0x79, 0x41, 0x3e}, // @
+	{0x7e, 0x11, 0x11, 0x11, 0x7e}, // A
+	{0x7f, 0x49, 0x49, 0x49, 0x36}, // B
+	{0x3e, 0x41, 0x41, 0x41, 0x22}, // C
+	{0x7f, 0x41, 0x41, 0x22, 0x1c}, // D
+	{0x7f, 0x49, 0x49, 0x49, 0x41}, // E
+	{0x7f, 0x09, 0x09, 0x09, 0x01}, // F
+	{0x3e, 0x41, 0x49, 0x49, 0x7a}, // G
+	{0x7f, 0x08, 0x08, 0x08, 0x7f}, // H
+	{0x00, 0x41, 0x7f, 0x41, 0x00}, // I
+	{0x20, 0x40, 0x41, 0x3f, 0x01}, // J
+	{0x7f, 0x08, 0x14, 0x22, 0x41}, // K
+	{0x7f, 0x40, 0x40, 0x40, 0x40}, // L
+	{0x7f, 0x02, 0x0c, 0x02, 0x7f}, // M
+	{0x7f, 0x04, 0x08, 0x10, 0x7f}, // N
+	{0x3e, 0x41, 0x41, 0x41, 0x3e}, // O
+	{0x7f, 0x09, 0x09, 0x09, 0x06}, // P
+	{0x3e, 0x41, 0x51, 0x21, 0x5e}, // Q
+	{0x7f, 0x09, 0x19, 0x29, 0x46}, // R
+	{0x46, 0x49, 0x49, 0x49, 0x31}, // S
+	{0x01, 0x01, 0x7f, 0x01, 0x01}, // T
+	{0x3f, 0x40, 0x40, 0x40, 0x3f}, // U
+	{0x1f, 0x20, 0x40, 0x20, 0x1f}, // V
+	{0x3f, 0x40, 0x38, 0x40, 0x3f}, // W
+	{0x63, 0x14, 0x08, 0x14, 0x63}, // X
+	{0x07, 0x08, 0x70, 0x08, 0x07}, // Y
+	{0x61, 0x51, 0x49, 0x45, 0x43}, // Z
+	{0x00, 0x7f, 0x41, 0x41, 0x00}, // [
+	{0x02, 0x04, 0x08, 0x10, 0x20}, // \
+	{0x00, 0x41, 0x41, 0x7f, 0x00}, // ]
+	{0x04, 0x02, 0x01, 0x02, 0x04}, // ^
+	{0x40, 0x40, 0x40, 0x40, 0x40}, // _
+	{0x00, 0x01, 0x02, 0x04, 0x00}, // `
+	{0x20, 0x54, 0x54, 0x54, 0x78}, // a
+	{0x7f, 0x48, 0x44, 0x44, 0x38}, // b
+	{0x38, 0x44, 0x44, 0x44, 0x20}, // c
+	{0x38, 0x44, 0x44, 0x48, 0x7f}, // d
+	{0x38, 0x54, 0x54, 0x54, 0x18}, // e
+	{0x08, 0x7e, 0x09, 0x01, 0x02}, // f
+	{0x18, 0xa4, 0xa4, 0xa4, 0x7c}, // g
+	{0x7f, 0x08, 0x04, 0x04, 0x78}, // h
+	{0x00, 0x44, 0x7d, 0x40, 0x00}, // i
+	{0x00, 0x80, 0x84, 0x7d, 0x00}, // j
+	{0x7f, 0x10, 0x28, 0x44, 0x00}, // k
+	{0x00, 0x41, 0x7f, 0x40, 0x00}, // l
+	{0x7c, 0x04, 0x18, 0x04, 0x78}, // m
+	{0x7c, 0x08, 0x04, 0x04, 0x78}, // n
+	{0x38, 0x44, 0x44, 0x44, 0x38}, // o
+	{0xfc, 0x24, 0x24, 0x24, 0x18}, // p
+	{0x18, 0x24, 0x24, 0x24, 0xfc}, // q
+	{0x7c, 0x08, 0x04, 0x04, 0x08}, // r
+	{0x48, 0x54, 0x54, 0x54, 0x20}, // s
+	{0x04, 0x3f, 0x44, 0x40, 0x20}, // t
+	{0x3c, 0x40, 0x40, 0x20, 0x7c}, // u
+	{0x1c, 0x20, 0x40, 0x20, 0x1c}, // v
+	{0x3c, 0x40, 0x30, 0x40, 0x3c}, // w
+	{0x44, 0x28, 0x10, 0x28, 0x44}, // x
+	{0x1c, 0xa0, 0xa0, 0xa0, 0x7c}, // y
+	{0x44, 0x64, 0x54, 0x4c, 0x44}, // z
+	{0x00, 0x08, 0x36, 0x41, 0x00}, // {
+	{0x00, 0x00, 0x7f, 0x00, 0x00}, // |
+	{0x00, 0x41, 0x36, 0x08, 0x00}, // }
+	{0x08, 0x04, 0x08, 0x10, 0x08}, // ~
+}
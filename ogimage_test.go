@@ -0,0 +1,63 @@
+package main
+
+import (
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestWrapText(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []string
+	}{
+		{"Hello", []string{"Hello"}},
+		{"Hello there world", []string{"Hello", "there", "world"}},
+		{"Hi to you all", []string{"Hi to", "you", "all"}},
+		{"Extraordinary", []string{"Extra", "ordin", "ary"}},
+		{"a b c d e f g h i j k l", []string{"a b c", "d e f", "g h i", "j k l"}},
+		{"a b c d e f g h i j k l m", []string{"a b c", "d e f", "g h i", "j ..."}},
+	} {
+		if got := wrapText(tc.in, 5, 4); !slices.Equal(got, tc.want) {
+			t.Errorf("wrapText(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestOGImage(t *testing.T) {
+	srv := newTestServer(t, testConfig, testFS())
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := get("/blog/first-post/og.png")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /blog/first-post/og.png = %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("content type = %q, want image/png", ct)
+	}
+	img, err := png.Decode(rec.Body)
+	if err != nil {
+		t.Fatalf("decoding image: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != ogWidth || b.Dy() != ogHeight {
+		t.Errorf("image is %dx%d, want %dx%d", b.Dx(), b.Dy(), ogWidth, ogHeight)
+	}
+	if again := get("/blog/first-post/og.png"); again.Header().Get("ETag") != rec.Header().Get("ETag") {
+		t.Error("image changed between requests")
+	}
+	if other := get("/blog/second-post/og.png"); other.Header().Get("ETag") == rec.Header().Get("ETag") {
+		t.Error("posts with different titles have the same image")
+	}
+
+	for _, path := range []string{"/blog/does-not-exist/og.png", "/blog/first-post/other.png"} {
+		if rec := get(path); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", path, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+)
+
+// previewToken is what lets someone see the draft with slug in production,
+// without it being public. It's derived from the slug and the preview
+// secret, so tokens don't need to be stored anywhere, and changing the
+// secret revokes all of them.
+func previewToken(secret, slug string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(slug))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validPreview reports whether token lets the draft with slug be seen.
+// Previews are disabled when there's no secret.
+func validPreview(secret, slug, token string) bool {
+	if secret == "" || token == "" {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(previewToken(secret, slug)))
+}
+
+// previewURL is the url to share for a preview of the draft with slug.
+func previewURL(cfg *Config, slug string) string {
+	return cfg.BaseURL + "/blog/" + slug + "?" + url.Values{"preview": {previewToken(cfg.PreviewSecret, slug)}}.Encode()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPreviewToken(t *testing.T) {
+	token := previewToken("secret", "draft-post")
+	if token != previewToken("secret", "draft-post") {
+		t.Error("preview token isn't deterministic")
+	}
+	for _, tc := range []struct {
+		secret, slug, token string
+		want                bool
+	}{
+		{"secret", "draft-post", token, true},
+		{"other", "draft-post", token, false},
+		{"secret", "other-post", token, false},
+		{"secret", "draft-post", "", false},
+		{"", "draft-post", previewToken("", "draft-post"), false},
+	} {
+		if got := validPreview(tc.secret, tc.slug, tc.token); got != tc.want {
+			t.Errorf("validPreview(%q, %q, %q) = %t, want %t", tc.secret, tc.slug, tc.token, got, tc.want)
+		}
+	}
+}
+
+func TestDraftPreview(t *testing.T) {
+	fsys := testFS()
+	fsys["posts/draft-post.md"] = &fstest.MapFile{Data: []byte(`---
+title: "Draft Post"
+published: "Mar 04 2023 PST"
+draft: true
+tags: [unannounced]
+---
+
+Not quite ready.
+`)}
+	cfg := *testConfig
+	cfg.PreviewSecret = "secret"
+	srv := newTestServer(t, &cfg, fsys)
+
+	preview := strings.TrimPrefix(previewURL(&cfg, "draft-post"), cfg.BaseURL)
+	for _, tc := range []struct {
+		path   string
+		status int
+	}{
+		{preview, http.StatusOK},
+		{"/blog/draft-post", http.StatusNotFound},
+		{"/blog/draft-post?preview=nope", http.StatusNotFound},
+		{"/blog/first-post?preview=" + previewToken("secret", "first-post"), http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.status {
+			t.Errorf("GET %s = %d, want %d", tc.path, rec.Code, tc.status)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/blog", nil))
+	if strings.Contains(rec.Body.String(), "draft-post") {
+		t.Error("draft is listed on the blog page")
+	}
+
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/blog/tags", nil))
+	if strings.Contains(rec.Body.String(), "unannounced") {
+		t.Error("tag only used by a draft is listed on the tags page")
+	}
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/blog/tag/unannounced", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /blog/tag/unannounced = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
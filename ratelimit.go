@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket per key: each key can make burst requests
+// at once, after which they're allowed at rate per second.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token from the bucket of key. If there are none left, it
+// returns how long until there will be.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep drops the buckets which have filled back up, since they're no
+// different from a new one. It runs at most once a minute.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimit limits the requests each client can make, see Config.RateLimit.
+// Requests for files (assets, feeds, robots.txt etc.) and health checks are
+// exempt, since they're cheap to serve and fetched in bulk.
+func rateLimit(cfg *Config, next http.Handler) http.Handler {
+	if cfg.RateLimit == 0 {
+		return next
+	}
+	limiter := newRateLimiter(cfg.RateLimit, cfg.RateBurst)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case path.Ext(r.URL.Path) != "", r.URL.Path == "/feed", r.URL.Path == "/healthz", r.URL.Path == "/readyz":
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, retryAfter := limiter.allow(clientIP(r), time.Now()); !ok {
+			tooManyRequests(w, retryAfter)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tooManyRequests responds with a 429, asking the client to retry after the
+// given delay (rounded up to the nearest second).
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(1, 2)
+	now := time.Now()
+	for i, want := range []bool{true, true, false} {
+		if got, _ := l.allow("a", now); got != want {
+			t.Errorf("request %d = %t, want %t", i, got, want)
+		}
+	}
+	if _, retryAfter := l.allow("a", now); retryAfter != time.Second {
+		t.Errorf("retry after = %s, want 1s", retryAfter)
+	}
+	if ok, _ := l.allow("b", now); !ok {
+		t.Error("other keys should have their own bucket")
+	}
+	if ok, _ := l.allow("a", now.Add(500*time.Millisecond)); ok {
+		t.Error("half a token shouldn't be enough")
+	}
+	if ok, _ := l.allow("a", now.Add(time.Second)); !ok {
+		t.Error("a token should have been added after a second")
+	}
+
+	l.allow("c", now)
+	l.allow("a", now.Add(2*time.Minute))
+	if _, ok := l.buckets["c"]; ok {
+		t.Error("full buckets should be swept")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	cfg := *testConfig
+	cfg.RateLimit, cfg.RateBurst = 1, 2
+	h := rateLimit(&cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	get := func(path, remoteAddr, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if got := get("/search", "192.0.2.1:1234", ""); got != want {
+			t.Errorf("request %d = %d, want %d", i, got, want)
+		}
+	}
+	for _, path := range []string{"/styles.css", "/feed.xml", "/feed", "/healthz"} {
+		if got := get(path, "192.0.2.1:1234", ""); got != http.StatusOK {
+			t.Errorf("GET %s = %d, it should be exempt", path, got)
+		}
+	}
+	// X-Forwarded-For is only used for requests from trusted proxies.
+	if got := get("/search", "192.0.2.1:1234", "198.51.100.1"); got != http.StatusTooManyRequests {
+		t.Errorf("request with a forged X-Forwarded-For = %d, want 429", got)
+	}
+
+}
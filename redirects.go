@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+const redirectsFile = "redirects.txt"
+
+// loadRedirects reads the redirects file from fsys, which maps old paths to
+// new ones with one "old new" pair per line. Blank lines and lines starting
+// with # are ignored. The file is optional.
+func loadRedirects(fsys fs.FS) (map[string]string, error) {
+	content, err := fs.ReadFile(fsys, redirectsFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	redirects := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected an old and a new path, got %q", n, line)
+		}
+		from, to := fields[0], fields[1]
+		if !strings.HasPrefix(from, "/") || !strings.HasPrefix(to, "/") {
+			return nil, fmt.Errorf("line %d: paths must start with /", n)
+		} else if _, ok := redirects[from]; ok {
+			return nil, fmt.Errorf("line %d: %s is redirected more than once", n, from)
+		}
+		redirects[from] = to
+	}
+	return redirects, scanner.Err()
+}
+
+// buildRedirects merges the redirects from the redirects file with the
+// aliases of posts, which redirect to the post itself.
+func buildRedirects(fromFile map[string]string, posts []*post) (map[string]string, error) {
+	redirects := make(map[string]string, len(fromFile))
+	for from, to := range fromFile {
+		redirects[from] = to
+	}
+	for _, p := range posts {
+		for _, alias := range p.Aliases {
+			if _, ok := redirects[alias]; ok {
+				return nil, fmt.Errorf("alias %s of post %s is already redirected", alias, p.Slug)
+			}
+			redirects[alias] = "/blog/" + p.Slug
+		}
+	}
+	return redirects, nil
+}
+
+// checkRedirects makes sure that every redirect points at a page which
+// exists, and comes from a path which doesn't, since the redirect would
+// never be reached otherwise. Both are checked by requesting them from h.
+func checkRedirects(h http.Handler, redirects map[string]string) error {
+	var errs []error
+	for from, to := range redirects {
+		if code := status(h, to); code != http.StatusOK {
+			errs = append(errs, fmt.Errorf("redirect from %s to %s: target responded with %d", from, to, code))
+		}
+		if code := status(h, from); code != http.StatusMovedPermanently {
+			errs = append(errs, fmt.Errorf("redirect from %s to %s: shadowed by a page which responded with %d", from, to, code))
+		}
+	}
+	return errors.Join(errs...)
+}
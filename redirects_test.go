@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRedirects(t *testing.T) {
+	srv := newTestServer(t, testConfig, testFS())
+	for _, tc := range []struct {
+		method, path string
+		status       int
+		location     string
+	}{
+		{http.MethodGet, "/posts", http.StatusMovedPermanently, "/blog"},
+		{http.MethodGet, "/posts?page=2", http.StatusMovedPermanently, "/blog?page=2"},
+		{http.MethodHead, "/blog/1st-post", http.StatusMovedPermanently, "/blog/first-post"},
+		{http.MethodGet, "/blog/1st-post", http.StatusMovedPermanently, "/blog/first-post"},
+		{http.MethodPost, "/posts", http.StatusNotFound, ""},
+	} {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		if rec.Code != tc.status {
+			t.Errorf("%s %s = %d, want %d", tc.method, tc.path, rec.Code, tc.status)
+		}
+		if got := rec.Header().Get("Location"); got != tc.location {
+			t.Errorf("%s %s redirected to %q, want %q", tc.method, tc.path, got, tc.location)
+		}
+	}
+}
+
+func TestLoadRedirects(t *testing.T) {
+	for _, tc := range []struct {
+		file    string
+		want    map[string]string
+		wantErr string
+	}{
+		{"", map[string]string{}, ""},
+		{"# comment\n\n/a /b\n  /c   /d  \n", map[string]string{"/a": "/b", "/c": "/d"}, ""},
+		{"/a\n", nil, "line 1: expected an old and a new path"},
+		{"/a /b /c\n", nil, "line 1: expected an old and a new path"},
+		{"a /b\n", nil, "line 1: paths must start with /"},
+		{"/a /b\n/a /c\n", nil, "line 2: /a is redirected more than once"},
+	} {
+		got, err := loadRedirects(fstest.MapFS{redirectsFile: &fstest.MapFile{Data: []byte(tc.file)}})
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("loadRedirects(%q) error = %v, want %q", tc.file, err, tc.wantErr)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("loadRedirects(%q): %v", tc.file, err)
+			continue
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("loadRedirects(%q) = %v, want %v", tc.file, got, tc.want)
+		}
+		for from, to := range tc.want {
+			if got[from] != to {
+				t.Errorf("loadRedirects(%q)[%s] = %q, want %q", tc.file, from, got[from], to)
+			}
+		}
+	}
+
+	if got, err := loadRedirects(fstest.MapFS{}); got != nil || err != nil {
+		t.Errorf("loadRedirects without a file = %v, %v", got, err)
+	}
+}
+
+func TestCheckRedirects(t *testing.T) {
+	for _, tc := range []struct {
+		name, redirects, wantErr string
+	}{
+		{"missing target", "/old /blog/does-not-exist\n", "target responded with 404"},
+		{"shadowed", "/blog /uses\n", "shadowed by a page which responded with 200"},
+		{"alias clash", "/blog/1st-post /blog\n", "alias /blog/1st-post of post first-post is already redirected"},
+	} {
+		fsys := testFS()
+		fsys[redirectsFile] = &fstest.MapFile{Data: []byte(tc.redirects)}
+		_, err := newServer(testConfig, fsys, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: newServer error = %v, want %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// plainText extracts the text of a rendered post, with runs of whitespace
+// collapsed into single spaces.
+func plainText(rendered []byte) string {
+	var buf strings.Builder
+	z := html.NewTokenizer(bytes.NewReader(rendered))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(buf.String()), " ")
+		case html.TextToken:
+			buf.WriteString(html.UnescapeString(string(z.Text())))
+		}
+	}
+}
+
+type searchResult struct {
+	Post    *post
+	Snippet string
+}
+
+const (
+	snippetRadius    = 80
+	titleMatchWeight = 10
+	maxSearchResults = 20
+)
+
+// search does a case-insensitive scan over the titles and text of posts,
+// returning the posts which contain every term of the query. Results are
+// ranked by the number of matches, title matches counting for more. There's
+// no index to speak of, so there's nothing to rebuild when content reloads.
+func search(posts []*post, query string) []searchResult {
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return nil
+	}
+
+	type scored struct {
+		searchResult
+		score int
+	}
+	var results []scored
+	for _, p := range posts {
+		title := strings.ToLower(p.Title)
+		text := strings.ToLower(p.text)
+		score := 0
+		for _, term := range terms {
+			n := strings.Count(title, term)*titleMatchWeight + strings.Count(text, term)
+			if n == 0 {
+				score = 0
+				break
+			}
+			score += n
+		}
+		if score == 0 {
+			continue
+		}
+		results = append(results, scored{
+			searchResult: searchResult{Post: p, Snippet: snippet(p.text, text, terms)},
+			score:        score,
+		})
+	}
+
+	// Stable, so equally ranked posts stay sorted newest first.
+	slices.SortStableFunc(results, func(a, b scored) int {
+		return cmp.Compare(b.score, a.score)
+	})
+
+	out := make([]searchResult, 0, min(len(results), maxSearchResults))
+	for _, r := range results[:min(len(results), maxSearchResults)] {
+		out = append(out, r.searchResult)
+	}
+	return out
+}
+
+// snippet returns a short excerpt of text around the first match of any of
+// the terms, lowered is the lowercased version of text used for matching.
+func snippet(text, lowered string, terms []string) string {
+	idx := -1
+	for _, term := range terms {
+		if i := strings.Index(lowered, term); i >= 0 && (idx < 0 || i < idx) {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		// Only matched the title.
+		idx = 0
+	}
+	if len(lowered) != len(text) {
+		// Lowercasing changed the byte length of some characters, so the
+		// offsets don't line up with the original text anymore.
+		text = lowered
+	}
+
+	start := max(0, idx-snippetRadius)
+	end := min(len(text), idx+snippetRadius)
+	// Widen to word boundaries, so words (and runes) aren't cut in half.
+	if i := strings.LastIndexByte(text[:start], ' '); start > 0 && i >= 0 {
+		start = i + 1
+	} else if start > 0 {
+		start = 0
+	}
+	if i := strings.IndexByte(text[end:], ' '); i >= 0 {
+		end += i
+	} else {
+		end = len(text)
+	}
+
+	out := text[start:end]
+	if start > 0 {
+		out = "…" + out
+	}
+	if end < len(text) {
+		out += "…"
+	}
+	return out
+}
+
+const (
+	maxSuggestions = 3
+	// maxSuggestionSlugLength bounds the work suggestPosts does for a
+	// request, since comparing slugs takes time in the product of their
+	// lengths. Real slugs are well short of it.
+	maxSuggestionSlugLength = 100
+	// minSuggestionSimilarity is how similar a slug has to be to the one
+	// which was requested to be suggested, from 0 to 1.
+	minSuggestionSimilarity = 0.5
+)
+
+// suggestPosts finds up to maxSuggestions posts with slugs close to slug,
+// for when it doesn't match any, i.e. because of a typo or a truncated url.
+// The most similar come first. A .md suffix is ignored, since that's the
+// same post's markdown source.
+func suggestPosts(posts []*post, slug string) []*post {
+	slug = strings.ToLower(strings.TrimSuffix(slug, ".md"))
+	if slug == "" || len(slug) > maxSuggestionSlugLength {
+		return nil
+	}
+	type scored struct {
+		post       *post
+		similarity float64
+	}
+	var matches []scored
+	for _, p := range posts {
+		if s := slugSimilarity(slug, p.Slug); s >= minSuggestionSimilarity {
+			matches = append(matches, scored{p, s})
+		}
+	}
+	// Stable, so equally similar posts stay sorted newest first.
+	slices.SortStableFunc(matches, func(a, b scored) int {
+		return cmp.Compare(b.similarity, a.similarity)
+	})
+	var out []*post
+	for _, m := range matches[:min(len(matches), maxSuggestions)] {
+		out = append(out, m.post)
+	}
+	return out
+}
+
+// slugSimilarity is the higher of how few edits it takes to turn a into b,
+// which catches typos, and how many of their words they share, which
+// catches words being left out or reordered.
+func slugSimilarity(a, b string) float64 {
+	edits := 1 - float64(editDistance(a, b))/float64(max(len(a), len(b)))
+
+	aWords, bWords := slugWords(a), slugWords(b)
+	shared := 0
+	for _, w := range aWords {
+		if slices.Contains(bWords, w) {
+			shared++
+		}
+	}
+	words := float64(shared) / float64(len(aWords)+len(bWords)-shared)
+
+	return max(edits, words)
+}
+
+// slugWords returns the distinct words of a slug.
+func slugWords(slug string) []string {
+	words := strings.Split(slug, "-")
+	slices.Sort(words)
+	return slices.Compact(words)
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Server is the website minus the listener: the config, the current content
+// (posts, templates, indexes and feeds) and the routes serving it. Handler
+// can be used with httptest without binding a port.
+type Server struct {
+	cfg     *Config
+	logger  *slog.Logger
+	store   *contentStore
+	mux     *http.ServeMux
+	started time.Time
+	build   buildInfo
+	// draining is set once the server starts shutting down, see drain.
+	draining atomic.Bool
+	// maintenance starts out as Config.Maintenance, and can be toggled
+	// while running, see reloadMaintenance.
+	maintenance atomic.Bool
+	// metrics is nil unless they are enabled.
+	metrics     *metrics
+	webmentions *webmentions
+	ogImages    *ogImages
+}
+
+// newServer loads the site content from fsys, laid out like the static
+// directory, and registers all of the routes.
+func newServer(cfg *Config, fsys fs.FS, logger *slog.Logger) (*Server, error) {
+	mux := http.NewServeMux()
+
+	assets, err := registerPublicDir(mux, fsys, cfg.AssetPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("registering public files with mux: %w", err)
+	}
+
+	for theme, style := range highlightStyles {
+		hlCSS, err := highlightCSS(style)
+		if err != nil {
+			return nil, fmt.Errorf("generating %s highlight css: %w", theme, err)
+		}
+		assets.register(mux, highlightAsset(theme), hlCSS, serveBytes("text/css; charset=utf-8", hlCSS))
+	}
+
+	if cfg.Image != "" {
+		if _, err := assets.url(cfg.Image); err != nil {
+			return nil, fmt.Errorf("checking SITE_IMAGE: %w", err)
+		}
+	}
+
+	if err := registerIcons(mux, cfg, fsys, assets); err != nil {
+		return nil, err
+	}
+
+	initial, err := loadContent(cfg, fsys, assets, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		cfg:      cfg,
+		logger:   logger,
+		store:    &contentStore{current: initial, cfg: cfg, fsys: fsys, assets: assets, logger: logger},
+		mux:      mux,
+		started:  time.Now(),
+		build:    readBuildInfo(),
+		ogImages: newOGImages(cfg),
+	}
+	s.maintenance.Store(cfg.Maintenance)
+	if cfg.MetricsEnabled {
+		s.metrics = newMetrics(mux)
+	}
+	s.webmentions, err = newWebmentions(cfg, func(path string) bool {
+		return status(trimTrailingSlash(mux), path) == http.StatusOK
+	}, logger)
+	if err != nil {
+		return nil, fmt.Errorf("loading webmentions: %w", err)
+	}
+	if err := s.routes(); err != nil {
+		return nil, err
+	}
+	s.store.warnUnusedTemplates()
+	if cfg.PreviewSecret != "" && !production() {
+		for _, p := range initial.allPosts {
+			if p.Draft {
+				logger.Debug("draft preview", slog.String("post", p.Slug), slog.String("url", previewURL(cfg, p.Slug)))
+			}
+		}
+	}
+	if err := s.checkContent(initial); err != nil {
+		return nil, err
+	}
+	s.store.check = s.checkContent
+	return s, nil
+}
+
+// checkContent checks the redirects and the links in the posts of c, by
+// requesting them from the routes served from c. Broken links are only an
+// error with STRICT_LINKS.
+func (s *Server) checkContent(c *siteContent) error {
+	if err := checkRedirects(withContent(s.mux, c), c.redirects); err != nil {
+		return fmt.Errorf("checking redirects: %w", err)
+	}
+	if err := checkLinks(s.cfg, withContent(trimTrailingSlash(s.mux), c), c.posts); err != nil {
+		if s.cfg.StrictLinks {
+			return fmt.Errorf("checking links: %w", err)
+		}
+		s.logger.Warn("found broken links", slog.String("error", err.Error()))
+	}
+	return nil
+}
+
+// findPost looks up the post with the slug in r's path. Drafts are only
+// found with a valid preview token, see previewToken.
+func (s *Server) findPost(r *http.Request, c *siteContent) *post {
+	slug := r.PathValue("slug")
+	if idx, ok := c.slugIndex[slug]; ok {
+		return c.posts[idx]
+	} else if draft, ok := c.drafts[slug]; ok && validPreview(s.cfg.PreviewSecret, slug, r.URL.Query().Get("preview")) {
+		return draft
+	}
+	return nil
+}
+
+// Handler returns the routes wrapped in all of the middleware.
+func (s *Server) Handler() http.Handler {
+	return buildHeader(s.build, resolveClientIP(s.cfg.TrustedProxies, logRequests(s.logger, s.metrics, requestTimeout(s.cfg.RequestTimeout, s.rejectWhileDraining(rateLimit(s.cfg, limitBody(s.cfg, headRequests(securityHeaders(s.cfg, cors(s.cfg, trimTrailingSlash(compress(themePreference(s.maintenanceMode(s.store.recoverPanics(s.mux)))))))))))))))
+}
+
+// drain marks the server as shutting down. Requests which are already in
+// flight carry on, but new ones are turned away, see rejectWhileDraining.
+func (s *Server) drain() {
+	s.draining.Store(true)
+}
+
+// rejectWhileDraining responds to requests with a 503 once the server is
+// draining, telling the client to retry once the next instance is up. The
+// health checks are let through, so load balancers can see what's going on.
+func (s *Server) rejectWhileDraining(next http.Handler) http.Handler {
+	retryAfter := strconv.Itoa(int(math.Ceil(s.cfg.ShutdownTimeout.Seconds())))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.draining.Load() || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", retryAfter)
+		w.Header().Set("Connection", "close")
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	})
+}
+
+// keepFresh keeps the content up to date until ctx is cancelled. In
+// production scheduled posts are published once due, whereas in dev the
+// content is reloaded whenever the files change.
+func (s *Server) keepFresh(ctx context.Context) {
+	if production() {
+		s.store.schedule(ctx, s.logger, time.Minute)
+	} else {
+		s.store.watch(ctx, s.logger, time.Second)
+	}
+}
+
+// reloadOnHangup reloads the content and re-reads MAINTENANCE whenever the
+// process gets a SIGHUP, until ctx is cancelled. This is how new posts are
+// picked up in production without a restart, when the site files are read
+// from CONTENT_DIR rather than the binary.
+func (s *Server) reloadOnHangup(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		s.logger.Info("reloading after SIGHUP")
+		s.reloadMaintenance()
+		if err := s.store.reload(s.logger); err != nil {
+			s.logger.Error("failed to reload content, keeping the previous content", slog.String("error", err.Error()))
+		}
+	}
+}
+
+func (s *Server) routes() error {
+	if err := s.store.registerHandler(s.mux, "GET /{$}", "index", func(_ *http.Request, c *siteContent) (any, error) {
+		// The featured post has a slot of its own, so isn't repeated in the
+		// recent posts.
+		var recent []*post
+		for _, p := range c.posts {
+			if len(recent) == 3 {
+				break
+			} else if p != c.featured {
+				recent = append(recent, p)
+			}
+		}
+		type innerType struct {
+			Featured    *post
+			RecentPosts []*post
+		}
+		return templateData[innerType]{
+			Inner: innerType{
+				Featured:    c.featured,
+				RecentPosts: recent,
+			},
+			Meta: pageMeta{URL: s.cfg.BaseURL + "/"},
+		}, nil
+	}); err != nil {
+		return fmt.Errorf("register index handler: %w", err)
+	}
+
+	if err := s.store.registerHandler(s.mux, "GET /blog", "blog", func(r *http.Request, c *siteContent) (any, error) {
+		filter := parseDateFilter(r)
+		posts := c.posts
+		if filter.Active() {
+			posts = nil
+			for _, p := range c.posts {
+				if filter.match(p.PublishedAt) {
+					posts = append(posts, p)
+				}
+			}
+		}
+		query := blogQuery{Filter: filter, Sort: parseBlogSort(r)}
+		posts = sortPosts(posts, query.Sort)
+		page, err := paginate(r, len(posts), s.cfg.PostsPerPage)
+		if err != nil {
+			return nil, err
+		}
+		meta := pageMeta{URL: s.cfg.BaseURL + query.URL(page.Current)}
+		if page.HasPrev {
+			meta.Prev = s.cfg.BaseURL + query.URL(page.Prev())
+		}
+		if page.HasNext {
+			meta.Next = s.cfg.BaseURL + query.URL(page.Next())
+		}
+		type innerType struct {
+			Posts []*post
+			Page  pagination
+			Query blogQuery
+			Sorts []string
+		}
+		return templateData[innerType]{
+			Inner: innerType{
+				Posts: posts[page.start:page.end],
+				Page:  page,
+				Query: query,
+				Sorts: blogSorts,
+			},
+			Subtitle: "Blog",
+			Meta:     meta,
+		}, nil
+	}); err != nil {
+		return fmt.Errorf("registering blog handler: %w", err)
+	}
+
+	postPage, err := s.store.templateHandler("blog_post", func(r *http.Request, c *siteContent) (any, error) {
+		p := s.findPost(r, c)
+		if p == nil {
+			return nil, errNotFound
+		}
+		ld, err := structuredData(s.cfg, p)
+		if err != nil {
+			return nil, fmt.Errorf("marshaling structured data for %s: %w", p.Slug, err)
+		}
+		type innerType struct {
+			*post
+			Related []*post
+			// Parts are the posts in the same series, including this one.
+			Parts          []*post
+			StructuredData template.JS
+		}
+		return templateData[innerType]{
+			Inner: innerType{
+				post:           p,
+				Related:        c.related[p.Slug],
+				Parts:          c.series[p.Series],
+				StructuredData: ld,
+			},
+			Subtitle: p.Title,
+			Meta: pageMeta{
+				Title:       p.Title,
+				Description: p.Description,
+				URL:         s.cfg.BaseURL + "/blog/" + p.Slug,
+				Canonical:   p.Canonical,
+				Image:       cmp.Or(s.cfg.publicURL(p.Cover), s.cfg.BaseURL+"/blog/"+p.Slug+"/og.png"),
+				Type:        "article",
+				NoIndex:     p.Draft,
+			},
+		}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("registering blog post handler: %w", err)
+	}
+	// Wildcards have to be a whole segment, so the markdown source of posts
+	// at /blog/{slug}.md is served from the same pattern.
+	s.mux.HandleFunc("GET /blog/{slug}", func(w http.ResponseWriter, r *http.Request) {
+		slug, ok := strings.CutSuffix(r.PathValue("slug"), ".md")
+		if !ok {
+			postPage(w, r)
+			return
+		}
+		r.SetPathValue("slug", slug)
+		if p := s.findPost(r, s.store.forRequest(r)); p != nil {
+			s.serveMarkdown(w, r, p)
+			return
+		}
+		s.store.notFound(w, r)
+	})
+
+	// This would be "GET /blog/{slug}/og.png", but that conflicts with the
+	// tag pages since neither pattern is more specific than the other.
+	s.mux.HandleFunc("GET /blog/{slug}/{file}", func(w http.ResponseWriter, r *http.Request) {
+		c := s.store.forRequest(r)
+		idx, ok := c.slugIndex[r.PathValue("slug")]
+		if !ok || r.PathValue("file") != "og.png" {
+			s.store.notFound(w, r)
+			return
+		}
+		s.ogImages.serve(w, r, c.posts[idx])
+	})
+
+	if err := s.store.registerHandler(s.mux, "GET /blog/archive", "archive", func(_ *http.Request, c *siteContent) (any, error) {
+		return templateData[[]yearGroup]{
+			Inner:    c.archive,
+			Subtitle: "Archive",
+			Meta:     pageMeta{URL: s.cfg.BaseURL + "/blog/archive"},
+		}, nil
+	}); err != nil {
+		return fmt.Errorf("registering archive handler: %w", err)
+	}
+
+	if err := s.store.registerHandler(s.mux, "GET /blog/tags", "tags", func(_ *http.Request, c *siteContent) (any, error) {
+		return templateData[[]tagCount]{
+			Inner:    c.tags,
+			Subtitle: "Tags",
+			Meta:     pageMeta{URL: s.cfg.BaseURL + "/blog/tags"},
+		}, nil
+	}); err != nil {
+		return fmt.Errorf("registering tags handler: %w", err)
+	}
+
+	if err := s.store.registerHandler(s.mux, "GET /blog/tag/{tag}", "tag", func(r *http.Request, c *siteContent) (any, error) {
+		tag := r.PathValue("tag")
+		tagged, ok := c.tagIndex[tag]
+		if !ok {
+			return nil, errNotFound
+		}
+		type innerType struct {
+			Tag   string
+			Posts []*post
+		}
+		return templateData[innerType]{
+			Inner: innerType{
+				Tag:   tag,
+				Posts: tagged,
+			},
+			Subtitle: "Posts tagged " + tag,
+			Meta:     pageMeta{URL: s.cfg.BaseURL + "/blog/tag/" + url.PathEscape(tag)},
+		}, nil
+	}); err != nil {
+		return fmt.Errorf("registering tag handler: %w", err)
+	}
+
+	if err := s.store.registerHandler(s.mux, "GET /blog/series/{name}", "series", func(r *http.Request, c *siteContent) (any, error) {
+		name := r.PathValue("name")
+		parts, ok := c.series[name]
+		if !ok {
+			return nil, errNotFound
+		}
+		type innerType struct {
+			Name  string
+			Posts []*post
+		}
+		return templateData[innerType]{
+			Inner: innerType{
+				Name:  name,
+				Posts: parts,
+			},
+			Subtitle: "Series: " + name,
+			Meta:     pageMeta{URL: s.cfg.BaseURL + "/blog/series/" + url.PathEscape(name)},
+		}, nil
+	}); err != nil {
+		return fmt.Errorf("registering series handler: %w", err)
+	}
+
+	if err := s.store.registerHandler(s.mux, "GET /search", "search", func(r *http.Request, c *siteContent) (any, error) {
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		type innerType struct {
+			Query   string
+			Results []searchResult
+		}
+		return templateData[innerType]{
+			Inner: innerType{
+				Query:   query,
+				Results: search(c.posts, query),
+			},
+			Subtitle: "Search",
+			Meta:     pageMeta{URL: s.cfg.BaseURL + "/search"},
+		}, nil
+	}); err != nil {
+		return fmt.Errorf("registering search handler: %w", err)
+	}
+
+	if err := s.store.registerHandler(s.mux, "GET /stats", "stats", func(_ *http.Request, c *siteContent) (any, error) {
+		return templateData[siteStats]{
+			Inner:    c.stats,
+			Subtitle: "Stats",
+			Meta:     pageMeta{URL: s.cfg.BaseURL + "/stats"},
+		}, nil
+	}); err != nil {
+		return fmt.Errorf("registering stats page: %w", err)
+	}
+
+	if err := s.store.registerHandler(s.mux, "GET /uses", "uses", func(_ *http.Request, _ *siteContent) (any, error) {
+		type innerType struct{}
+		return templateData[innerType]{
+			Subtitle: "Uses",
+			Meta:     pageMeta{URL: s.cfg.BaseURL + "/uses"},
+		}, nil
+	}); err != nil {
+		return fmt.Errorf("registering uses page: %w", err)
+	}
+
+	rss := s.store.serveBytes("application/rss+xml", func(_ *http.Request, c *siteContent) *precompressed {
+		return c.rss
+	})
+	atom := s.store.serveBytes("application/atom+xml", func(_ *http.Request, c *siteContent) *precompressed {
+		return c.atom
+	})
+	jsonFeed := s.store.serveBytes("application/feed+json", func(_ *http.Request, c *siteContent) *precompressed {
+		return c.jsonFeed
+	})
+	s.mux.HandleFunc("GET /feed.xml", rss)
+	s.mux.HandleFunc("GET /atom.xml", atom)
+	s.mux.HandleFunc("GET /feed.json", jsonFeed)
+	// /feed serves whichever of the feeds the client asks for.
+	s.mux.HandleFunc("GET /feed", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		switch negotiateMediaType(r.Header.Get("Accept"), "application/rss+xml", "application/atom+xml", "application/feed+json", "application/json") {
+		case "application/atom+xml":
+			atom(w, r)
+		case "application/feed+json", "application/json":
+			jsonFeed(w, r)
+		default:
+			rss(w, r)
+		}
+	})
+	s.mux.HandleFunc("GET /blog/tag/{tag}/feed.xml", s.store.serveBytes("application/rss+xml", func(r *http.Request, c *siteContent) *precompressed {
+		return c.tagFeeds[r.PathValue("tag")]
+	}))
+	s.mux.HandleFunc("GET /sitemap.xml", s.store.serveBytes("application/xml", func(_ *http.Request, c *siteContent) *precompressed {
+		return c.sitemap
+	}))
+
+	s.registerAPI()
+
+	s.mux.HandleFunc("POST /theme", setTheme)
+	s.mux.Handle("POST /webmention", s.webmentions)
+
+	// A robots.txt under public/ takes precedence, unless it's served under
+	// a prefix where crawlers won't look for it.
+	if s.store.assets.prefix != "" || !s.store.assets.has("robots.txt") {
+		s.mux.HandleFunc("GET /robots.txt", serveBytes("text/plain; charset=utf-8", buildRobots(s.cfg)))
+	}
+
+	// Metrics are served on the admin address instead, if there is one.
+	if s.metrics != nil && s.cfg.MetricsAddr == "" {
+		s.mux.Handle("GET /metrics", s.metrics)
+	}
+
+	s.mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		status := "ok"
+		if s.draining.Load() {
+			status = "draining"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Status      string    `json:"status"`
+			Maintenance bool      `json:"maintenance"`
+			Uptime      string    `json:"uptime"`
+			Posts       int       `json:"posts"`
+			Build       buildInfo `json:"build"`
+		}{
+			Status:      status,
+			Maintenance: s.maintenance.Load(),
+			Uptime:      time.Since(s.started).Round(time.Second).String(),
+			Posts:       len(s.store.forRequest(r).posts),
+			Build:       s.build,
+		})
+	})
+	// The server only starts listening once the initial content has been
+	// loaded, and a failed reload keeps the previous content, so the only
+	// time it isn't ready is while draining. Load balancers should stop
+	// sending traffic then.
+	s.mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if s.draining.Load() {
+			http.Error(w, "draining", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	if err := s.store.registerErrorPages(s.mux); err != nil {
+		return fmt.Errorf("registering error pages: %w", err)
+	}
+
+	return nil
+}
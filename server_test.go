@@ -0,0 +1,682 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// newTestServer creates a Server with cfg, backed by fsys.
+func newTestServer(t *testing.T, cfg *Config, fsys fs.FS) *Server {
+	t.Helper()
+	srv, err := newServer(cfg, fsys, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("creating server: %v", err)
+	}
+	return srv
+}
+
+// testFS is a fixture filesystem with two posts and bare-bones templates,
+// so tests don't depend on the real site.
+func testFS() fstest.MapFS {
+	page := func(body string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(`{{define "content"}}` + body + `{{end}}`)}
+	}
+	return fstest.MapFS{
+		"public/styles.css": &fstest.MapFile{Data: []byte("body {}")},
+		"templates/base.tmpl.html": &fstest.MapFile{Data: []byte(
+			`{{define "base"}}<html class="theme-{{.Theme}}"><title>{{.Subtitle}}</title><link href="{{asset "styles.css"}}">{{template "content" .}}<footer>updated {{isoDate .Updated}}</footer>{{end}}`,
+		)},
+		"templates/index.tmpl.html":       page(`{{with .Inner.Featured}}featured {{.Title}}:{{end}}{{range .Inner.RecentPosts}}<a href="/blog/{{.Slug}}">{{.Title}}</a>{{end}}`),
+		"templates/blog.tmpl.html":        page(`{{with .Inner.Query}}{{if .Filter.Active}}from {{.Filter}}:{{end}}{{if ne .Sort "newest"}}sorted by {{.Sort}}:{{end}}{{end}}{{range .Inner.Posts}}<a href="/blog/{{.Slug}}">{{.Title}}</a>{{end}}`),
+		"templates/blog_post.tmpl.html":   page(`<script type="application/ld+json">{{.Inner.StructuredData}}</script><h1>{{.Inner.Title}}</h1>{{.Inner.Content}}`),
+		"templates/archive.tmpl.html":     page(`archive`),
+		"templates/tag.tmpl.html":         page(`tag {{.Inner.Tag}}`),
+		"templates/tags.tmpl.html":        page(`{{range .Inner}}{{.Tag}}:{{.Count}}:{{.Weight}};{{end}}`),
+		"templates/series.tmpl.html":      page(`series {{.Inner.Name}}:{{range .Inner.Posts}}{{.SeriesOrder}}.{{.Slug}};{{end}}`),
+		"templates/search.tmpl.html":      page(`search`),
+		"templates/uses.tmpl.html":        page(`uses`),
+		"templates/stats.tmpl.html":       page(`{{with .Inner}}{{.Posts}} posts, {{.Words}} words, {{.AverageWords}} on average, {{isoDate .First}} to {{isoDate .Last}}{{end}}`),
+		"templates/404.tmpl.html":         page(`Not found{{range .Inner.Suggestions}} maybe {{.Slug}}{{end}}`),
+		"templates/500.tmpl.html":         page(`Server error`),
+		"templates/maintenance.tmpl.html": page(`Maintenance`),
+		"posts/first-post.md": &fstest.MapFile{Data: []byte(`---
+title: "First Post"
+published: "Jan 02 2023 PST"
+aliases: [/blog/1st-post]
+---
+
+Hello from the first post.
+`)},
+		"posts/second-post.md": &fstest.MapFile{Data: []byte(`---
+title: "Second Post"
+published: "Feb 03 2023 PST"
+tags: [go]
+---
+
+Hello from the second post.
+`)},
+		"redirects.txt": &fstest.MapFile{Data: []byte("# Old paths\n/posts /blog\n")},
+	}
+}
+
+func TestServerRoutes(t *testing.T) {
+	srv := newTestServer(t, testConfig, testFS())
+	for _, tc := range []struct {
+		method, path string
+		status       int
+		contains     []string
+	}{
+		{http.MethodGet, "/", http.StatusOK, []string{
+			`<a href="/blog/second-post">Second Post</a><a href="/blog/first-post">First Post</a>`,
+		}},
+		{http.MethodGet, "/blog", http.StatusOK, []string{
+			"<title>Blog</title>",
+			`<a href="/blog/first-post">First Post</a>`,
+		}},
+		{http.MethodGet, "/blog?year=2023&month=01", http.StatusOK, []string{
+			`from January 2023:<a href="/blog/first-post">First Post</a>`,
+		}},
+		{http.MethodGet, "/blog?year=2023&month=nope", http.StatusOK, []string{
+			`from 2023:<a href="/blog/second-post">Second Post</a><a href="/blog/first-post">First Post</a>`,
+		}},
+		{http.MethodGet, "/blog?month=02", http.StatusOK, []string{
+			`<a href="/blog/second-post">Second Post</a><a href="/blog/first-post">First Post</a>`,
+		}},
+		{http.MethodGet, "/blog?sort=oldest", http.StatusOK, []string{
+			`sorted by oldest:<a href="/blog/first-post">First Post</a><a href="/blog/second-post">Second Post</a>`,
+		}},
+		{http.MethodGet, "/blog?sort=sideways", http.StatusOK, []string{
+			`<a href="/blog/second-post">Second Post</a><a href="/blog/first-post">First Post</a>`,
+		}},
+		{http.MethodGet, "/blog?year=2023&sort=title", http.StatusOK, []string{
+			`from 2023:sorted by title:<a href="/blog/first-post">First Post</a><a href="/blog/second-post">Second Post</a>`,
+		}},
+		{http.MethodGet, "/blog?year=2022&page=2", http.StatusNotFound, []string{"Not found"}},
+		{http.MethodGet, "/blog/tags", http.StatusOK, []string{"<title>Tags</title>", "go:1:1;"}},
+		{http.MethodGet, "/blog/first-post", http.StatusOK, []string{
+			"<h1>First Post</h1>",
+			`"@type":"BlogPosting","headline":"First Post"`,
+			"<p>Hello from the first post.</p>",
+		}},
+		{http.MethodGet, "/blog/does-not-exist", http.StatusNotFound, []string{"Not found<footer>"}},
+		{http.MethodGet, "/blog/first-post.md", http.StatusOK, []string{
+			"---\ntitle: \"First Post\"",
+			"Hello from the first post.",
+		}},
+		{http.MethodGet, "/blog/does-not-exist.md", http.StatusNotFound, []string{"Not found"}},
+		{http.MethodGet, "/blog/frist-post", http.StatusNotFound, []string{"Not found maybe first-post<footer>"}},
+		{http.MethodGet, "/blog/tag/go", http.StatusOK, []string{"tag go"}},
+		{http.MethodGet, "/feed.xml", http.StatusOK, []string{
+			"<title>Second Post</title>",
+			"<link>https://morgangallant.com/blog/first-post</link>",
+		}},
+		{http.MethodGet, "/blog/tag/go/feed.xml", http.StatusOK, []string{
+			"<title>Morgan Gallant&#39;s blog — tag: go</title>",
+			"<link>https://morgangallant.com/blog/tag/go</link>",
+			"<title>Second Post</title>",
+		}},
+		{http.MethodGet, "/blog/tag/nope/feed.xml", http.StatusNotFound, []string{"Not found"}},
+		{http.MethodGet, "/sitemap.xml", http.StatusOK, []string{
+			"<loc>https://morgangallant.com/blog/tags</loc>",
+			"<loc>https://morgangallant.com/blog/first-post</loc>",
+			"<loc>https://morgangallant.com/blog/tag/go</loc>\n    <lastmod>2023-02-03</lastmod>",
+		}},
+		{http.MethodGet, "/robots.txt", http.StatusOK, []string{
+			"Disallow: /",
+			"Sitemap: https://morgangallant.com/sitemap.xml",
+		}},
+		{http.MethodGet, "/stats", http.StatusOK, []string{
+			"2 posts, 10 words, 5 on average, 2023-01-02 to 2023-02-03",
+		}},
+		{http.MethodHead, "/blog/first-post", http.StatusOK, nil},
+		{http.MethodPost, "/blog/first-post", http.StatusMethodNotAllowed, nil},
+		{http.MethodGet, "/nope", http.StatusNotFound, []string{"Not found"}},
+	} {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		if rec.Code != tc.status {
+			t.Errorf("%s %s = %d, want %d", tc.method, tc.path, rec.Code, tc.status)
+		}
+		body := rec.Body.String()
+		for _, want := range tc.contains {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s %s is missing %q, got:\n%s", tc.method, tc.path, want, body)
+			}
+		}
+	}
+}
+
+func TestPublicContentTypes(t *testing.T) {
+	fsys := testFS()
+	fsys["public/fonts/inter.woff2"] = &fstest.MapFile{Data: []byte("wOF2")}
+	fsys["public/photo.webp"] = &fstest.MapFile{Data: []byte("RIFF\x00\x00\x00\x00WEBPVP8 ")}
+	srv := newTestServer(t, testConfig, fsys)
+	for path, want := range map[string]string{
+		"/fonts/inter.woff2": "font/woff2",
+		"/photo.webp":        "image/webp",
+	} {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if got := rec.Header().Get("Content-Type"); rec.Code != http.StatusOK || got != want {
+			t.Errorf("GET %s = %d with Content-Type %q, want %q", path, rec.Code, got, want)
+		}
+	}
+}
+
+func TestAssetPrefix(t *testing.T) {
+	cfg := *testConfig
+	cfg.AssetPrefix = "/assets"
+	srv := newTestServer(t, &cfg, testFS())
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(rec.Body.String(), `<link href="/assets/styles.`) {
+		t.Errorf("stylesheet isn't linked under the prefix:\n%s", rec.Body)
+	}
+	for path, want := range map[string]int{
+		"/assets/styles.css": http.StatusOK,
+		"/styles.css":        http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("GET %s = %d, want %d", path, rec.Code, want)
+		}
+	}
+	if got := cfg.publicURL("cover.png"); got != "https://morgangallant.com/assets/cover.png" {
+		t.Errorf("publicURL = %s", got)
+	}
+}
+
+func TestPublicRobots(t *testing.T) {
+	for _, tc := range []struct {
+		prefix, want string
+	}{
+		{"", "from public"},
+		{"/static", string(buildRobots(testConfig))},
+	} {
+		cfg := *testConfig
+		cfg.AssetPrefix = tc.prefix
+		fsys := testFS()
+		fsys["public/robots.txt"] = &fstest.MapFile{Data: []byte("from public")}
+		srv := newTestServer(t, &cfg, fsys)
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/robots.txt", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != tc.want {
+			t.Errorf("GET /robots.txt with prefix %q = %d %q, want %q", tc.prefix, rec.Code, rec.Body, tc.want)
+		}
+	}
+}
+
+func TestUnusedTemplates(t *testing.T) {
+	var logs bytes.Buffer
+	if _, err := newServer(testConfig, testFS(), slog.New(slog.NewTextHandler(&logs, nil))); err != nil {
+		t.Fatalf("creating server: %v", err)
+	}
+	if strings.Contains(logs.String(), "aren't used") {
+		t.Errorf("warned about unused templates when there are none:\n%s", logs.String())
+	}
+
+	logs.Reset()
+	fsys := testFS()
+	fsys["templates/forgotten.tmpl.html"] = &fstest.MapFile{Data: []byte(`{{define "content"}}forgotten{{end}}`)}
+	if _, err := newServer(testConfig, fsys, slog.New(slog.NewTextHandler(&logs, nil))); err != nil {
+		t.Fatalf("creating server with an unused template: %v", err)
+	}
+	if !strings.Contains(logs.String(), "templates=forgotten") {
+		t.Errorf("didn't warn about the unused template:\n%s", logs.String())
+	}
+}
+
+func TestFeaturedPost(t *testing.T) {
+	fsys := testFS()
+	for name, file := range fsys {
+		if strings.HasPrefix(name, "posts/") {
+			file.Data = []byte(strings.Replace(string(file.Data), "---\n\n", "featured: true\n---\n\n", 1))
+		}
+	}
+	fsys["posts/third-post.md"] = &fstest.MapFile{Data: []byte(`---
+title: "Third Post"
+published: "Mar 04 2023 PST"
+---
+
+Not featured.
+`)}
+	srv := newTestServer(t, testConfig, fsys)
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	want := `featured Second Post:<a href="/blog/third-post">Third Post</a><a href="/blog/first-post">First Post</a><footer>`
+	if !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("homepage doesn't feature the most recent featured post, got:\n%s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	newTestServer(t, testConfig, testFS()).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if strings.Contains(rec.Body.String(), "featured") {
+		t.Errorf("homepage has a featured post without any being featured, got:\n%s", rec.Body)
+	}
+}
+
+func TestSeries(t *testing.T) {
+	fsys := testFS()
+	for name, order := range map[string]string{"posts/first-post.md": "2", "posts/second-post.md": "1"} {
+		file := fsys[name]
+		file.Data = []byte(strings.Replace(string(file.Data), "---\n\n", "series: Go Basics\nseries_order: "+order+"\n---\n\n", 1))
+	}
+	srv := newTestServer(t, testConfig, fsys)
+	for _, tc := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/blog/series/Go%20Basics", http.StatusOK, "series Go Basics:1.second-post;2.first-post;"},
+		{"/blog/series/go-basics", http.StatusNotFound, ""},
+		{"/sitemap.xml", http.StatusOK, "<loc>https://morgangallant.com/blog/series/Go%20Basics</loc>"},
+	} {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.status || !strings.Contains(rec.Body.String(), tc.body) {
+			t.Errorf("GET %s = %d, want %d with %q, got:\n%s", tc.path, rec.Code, tc.status, tc.body, rec.Body)
+		}
+	}
+
+	fsys["posts/third-post.md"] = &fstest.MapFile{Data: []byte(`---
+title: "Third Post"
+published: "Mar 04 2023 PST"
+series: Go Basics
+series_order: 1
+---
+
+Also part one.
+`)}
+	if _, err := newServer(testConfig, fsys, slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil || !strings.Contains(err.Error(), "both part 1 of series Go Basics") {
+		t.Errorf("creating server with two first parts = %v", err)
+	}
+
+	fsys["posts/third-post.md"].Data = []byte(strings.Replace(string(fsys["posts/third-post.md"].Data), "series: Go Basics\n", "", 1))
+	if _, err := loadPost(testConfig, fsys, "posts/third-post.md"); err == nil || !strings.Contains(err.Error(), "series_order is set") {
+		t.Errorf("creating server with a series_order but no series = %v", err)
+	}
+}
+
+func TestReloadOnHangup(t *testing.T) {
+	fsys := testFS()
+	srv := newTestServer(t, testConfig, fsys)
+	fsys["posts/third-post.md"] = &fstest.MapFile{Data: []byte(`---
+title: "Third Post"
+published: "Mar 04 2023 PST"
+---
+
+Added while running.
+`)}
+	t.Setenv("MAINTENANCE", "true")
+
+	// The signal is sent until it's noticed, since the goroutine may not be
+	// listening for it yet. Until then it'd kill the test binary, unless it's
+	// caught here too.
+	caught := make(chan os.Signal, 1)
+	signal.Notify(caught, syscall.SIGHUP)
+	defer signal.Stop(caught)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.reloadOnHangup(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(srv.store.get().posts) != 3 || !srv.maintenance.Load() {
+		if time.Now().After(deadline) {
+			t.Fatalf("after a SIGHUP there are %d posts and maintenance is %t, want 3 and true", len(srv.store.get().posts), srv.maintenance.Load())
+		}
+		if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReloadKeepsContentOnError(t *testing.T) {
+	fsys := testFS()
+	srv := newTestServer(t, testConfig, fsys)
+	before := srv.store.get()
+	fsys["templates/index.tmpl.html"] = &fstest.MapFile{Data: []byte(`{{define "content"}}{{end`)}
+	if err := srv.store.reload(srv.logger); err == nil {
+		t.Fatal("reloading with a broken template succeeded")
+	}
+	if srv.store.get() != before {
+		t.Error("the content was replaced after a failed reload")
+	}
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET / after a failed reload = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestReloadChecksContent(t *testing.T) {
+	for _, tc := range []struct {
+		name, file, data string
+	}{
+		{"broken redirect", "redirects.txt", "/old /nowhere\n"},
+		{"new cover image", "posts/third-post.md", `---
+title: "Third Post"
+published: "Mar 04 2023 PST"
+cover: "new.png"
+---
+
+Added while running.
+`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fsys := testFS()
+			srv := newTestServer(t, testConfig, fsys)
+			before := srv.store.get()
+			fsys["public/new.png"] = &fstest.MapFile{Data: []byte("png")}
+			fsys[tc.file] = &fstest.MapFile{Data: []byte(tc.data)}
+			if err := srv.store.reload(srv.logger); err == nil {
+				t.Fatal("reload succeeded")
+			}
+			if srv.store.get() != before {
+				t.Error("the content was replaced after a failed reload")
+			}
+		})
+	}
+}
+
+func TestFailedReloadIsNeverServed(t *testing.T) {
+	fsys := testFS()
+	srv := newTestServer(t, testConfig, fsys)
+	// Plenty of redirects to check, so that requests overlap with it.
+	var redirects strings.Builder
+	for i := range 200 {
+		fmt.Fprintf(&redirects, "/old-%d /blog/third-post\n", i)
+	}
+	redirects.WriteString("/broken /nowhere\n")
+	fsys[redirectsFile] = &fstest.MapFile{Data: []byte(redirects.String())}
+	fsys["posts/third-post.md"] = &fstest.MapFile{Data: []byte(`---
+title: "Third Post"
+published: "Mar 04 2023 PST"
+---
+
+Rejected along with the redirects.
+`)}
+
+	done := make(chan struct{})
+	served := make(chan int, 1)
+	go func() {
+		defer close(served)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/blog/third-post", nil))
+			if rec.Code != http.StatusNotFound {
+				served <- rec.Code
+				return
+			}
+		}
+	}()
+	for range 10 {
+		if err := srv.store.reload(srv.logger); err == nil {
+			t.Fatal("reload succeeded")
+		}
+	}
+	close(done)
+	if code, ok := <-served; ok {
+		t.Errorf("GET /blog/third-post during a failed reload = %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestLastUpdated(t *testing.T) {
+	srv := newTestServer(t, testConfig, testFS())
+	for _, path := range []string{"/", "/blog/first-post", "/does-not-exist"} {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if body := rec.Body.String(); !strings.Contains(body, "updated 2023-02-03") {
+			t.Errorf("GET %s doesn't show when the site was last updated:\n%s", path, body)
+		}
+	}
+}
+
+func TestFeedConditionalGet(t *testing.T) {
+	srv := newTestServer(t, testConfig, testFS())
+	for _, path := range []string{"/feed.xml", "/atom.xml", "/feed.json", "/sitemap.xml", "/blog/tag/go/feed.xml"} {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		etag, lastModified := rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+		if rec.Code != http.StatusOK || etag == "" || lastModified == "" {
+			t.Fatalf("GET %s = %d with ETag %q and Last-Modified %q", path, rec.Code, etag, lastModified)
+		}
+
+		for _, tc := range []struct {
+			header, value string
+			status        int
+		}{
+			{"If-None-Match", etag, http.StatusNotModified},
+			{"If-None-Match", `W/"stale"`, http.StatusOK},
+			{"If-Modified-Since", lastModified, http.StatusNotModified},
+			{"If-Modified-Since", "Mon, 02 Jan 2006 15:04:05 GMT", http.StatusOK},
+		} {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set(tc.header, tc.value)
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, req)
+			if rec.Code != tc.status {
+				t.Errorf("GET %s with %s: %s = %d, want %d", path, tc.header, tc.value, rec.Code, tc.status)
+			}
+		}
+	}
+}
+
+func TestPrecompressedFeeds(t *testing.T) {
+	srv := newTestServer(t, testConfig, testFS())
+	for _, path := range []string{"/feed.xml", "/atom.xml", "/feed.json", "/sitemap.xml", "/blog/tag/go/feed.xml"} {
+		get := func(acceptEncoding string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("GET %s with Accept-Encoding %q = %d", path, acceptEncoding, rec.Code)
+			}
+			return rec
+		}
+
+		plain := get("identity")
+		if enc := plain.Header().Get("Content-Encoding"); enc != "" {
+			t.Errorf("GET %s without gzip: Content-Encoding = %q", path, enc)
+		}
+
+		for _, tc := range []struct {
+			encoding string
+			decode   func(io.Reader) (io.Reader, error)
+		}{
+			{"gzip", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+			{"deflate", func(r io.Reader) (io.Reader, error) { return flate.NewReader(r), nil }},
+		} {
+			// Only the gzipped copy is precomputed, compress deflates the
+			// response on the fly if it's large enough.
+			rec := get(tc.encoding)
+			enc := rec.Header().Get("Content-Encoding")
+			if tc.encoding == "gzip" && enc != "gzip" {
+				t.Errorf("GET %s with gzip: Content-Encoding = %q", path, enc)
+				continue
+			} else if enc == "" {
+				continue
+			}
+			zr, err := tc.decode(rec.Body)
+			if err != nil {
+				t.Fatalf("GET %s with %s: %v", path, tc.encoding, err)
+			}
+			body, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatalf("GET %s with %s: decompressing: %v", path, tc.encoding, err)
+			}
+			if !bytes.Equal(body, plain.Body.Bytes()) {
+				t.Errorf("GET %s with %s doesn't decompress to the uncompressed response", path, tc.encoding)
+			}
+		}
+	}
+}
+
+func TestDraining(t *testing.T) {
+	srv := newTestServer(t, testConfig, testFS())
+	srv.drain()
+	for _, tc := range []struct {
+		path     string
+		status   int
+		contains string
+	}{
+		{"/", http.StatusServiceUnavailable, "Service Unavailable"},
+		{"/feed.xml", http.StatusServiceUnavailable, "Service Unavailable"},
+		{"/readyz", http.StatusServiceUnavailable, "draining"},
+		{"/healthz", http.StatusOK, `"status":"draining"`},
+	} {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.status {
+			t.Errorf("GET %s while draining = %d, want %d", tc.path, rec.Code, tc.status)
+		}
+		if !strings.Contains(rec.Body.String(), tc.contains) {
+			t.Errorf("GET %s while draining is missing %q, got:\n%s", tc.path, tc.contains, rec.Body.String())
+		}
+		if tc.status == http.StatusServiceUnavailable && tc.path != "/readyz" && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("GET %s while draining has no Retry-After", tc.path)
+		}
+	}
+}
+
+func TestNegotiatedFeed(t *testing.T) {
+	srv := newTestServer(t, testConfig, testFS())
+	for _, tc := range []struct {
+		accept, contentType, contains string
+	}{
+		{"", "application/rss+xml", "<rss"},
+		{"application/atom+xml", "application/atom+xml", "<feed"},
+		{"application/json", "application/feed+json", `"version":`},
+		{"text/html, */*;q=0.1", "application/rss+xml", "<rss"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/feed", nil)
+		req.Header.Set("Accept", tc.accept)
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		if got := rec.Header().Get("Content-Type"); rec.Code != http.StatusOK || got != tc.contentType {
+			t.Errorf("GET /feed accepting %q = %d %s, want 200 %s", tc.accept, rec.Code, got, tc.contentType)
+		}
+		if !strings.Contains(rec.Body.String(), tc.contains) {
+			t.Errorf("GET /feed accepting %q is missing %q", tc.accept, tc.contains)
+		}
+		if !strings.Contains(strings.Join(rec.Header().Values("Vary"), ","), "Accept") {
+			t.Errorf("GET /feed doesn't vary on Accept: %v", rec.Header().Values("Vary"))
+		}
+	}
+}
+
+// TestSitePages renders pages of the real site, rather than the fixture.
+func TestSitePages(t *testing.T) {
+	fsys, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := *testConfig
+	cfg.SiteTitle = "Morgan Gallant"
+	srv := newTestServer(t, &cfg, fsys)
+	for _, path := range []string{"/", "/blog", "/blog/hello-world", "/blog/archive", "/uses", "/stats", "/search?q=go", "/nope"} {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		// Pages are minified, so look at the parsed links rather than the
+		// markup.
+		var feeds []string
+		for _, attrs := range linkTags(rec.Body) {
+			if attrs["rel"] == "alternate" {
+				feeds = append(feeds, attrs["type"]+" "+attrs["title"]+" "+attrs["href"])
+			}
+		}
+		want := []string{
+			"application/rss+xml Morgan Gallant (RSS) https://morgangallant.com/feed.xml",
+			"application/atom+xml Morgan Gallant (Atom) https://morgangallant.com/atom.xml",
+			"application/feed+json Morgan Gallant (JSON Feed) https://morgangallant.com/feed.json",
+		}
+		if !slices.Equal(feeds, want) {
+			t.Errorf("GET %s links to feeds %q, want %q", path, feeds, want)
+		}
+	}
+}
+
+// linkTags returns the attributes of each <link> in the html read from r.
+func linkTags(r io.Reader) []map[string]string {
+	var links []map[string]string
+	z := html.NewTokenizer(r)
+	for tt := z.Next(); tt != html.ErrorToken; tt = z.Next() {
+		tok := z.Token()
+		if tok.Data != "link" {
+			continue
+		}
+		attrs := make(map[string]string)
+		for _, attr := range tok.Attr {
+			attrs[attr.Key] = attr.Val
+		}
+		links = append(links, attrs)
+	}
+	return links
+}
+
+func TestPaginationLinks(t *testing.T) {
+	// The real templates, with enough posts for three pages.
+	static, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := syntheticPosts(2*testConfig.PostsPerPage + 1)
+	if err := fs.WalkDir(static, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasPrefix(path, "posts/") {
+			return err
+		}
+		data, err := fs.ReadFile(static, path)
+		fsys[path] = &fstest.MapFile{Data: data}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t, testConfig, fsys)
+
+	for _, tc := range []struct {
+		path, prev, next string
+	}{
+		{"/blog", "", "https://morgangallant.com/blog?page=2"},
+		{"/blog?page=2", "https://morgangallant.com/blog", "https://morgangallant.com/blog?page=3"},
+		{"/blog?page=3&sort=oldest", "https://morgangallant.com/blog?page=2&sort=oldest", ""},
+	} {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		var prev, next string
+		for _, attrs := range linkTags(rec.Body) {
+			switch attrs["rel"] {
+			case "prev":
+				prev = attrs["href"]
+			case "next":
+				next = attrs["href"]
+			}
+		}
+		if prev != tc.prev || next != tc.next {
+			t.Errorf("GET %s links to prev %q and next %q, want %q and %q", tc.path, prev, next, tc.prev, tc.next)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// serveMarkdown serves the markdown source of the post, for reading as
+// plain text. Whether the frontmatter is included is up to
+// Config.SourceFrontmatter.
+func (s *Server) serveMarkdown(w http.ResponseWriter, r *http.Request, p *post) {
+	source := p.source
+	if !s.cfg.SourceFrontmatter {
+		source = stripFrontmatter(source)
+	}
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	if p.Draft {
+		w.Header().Set("X-Robots-Tag", "noindex")
+	}
+	http.ServeContent(w, r, "", p.LastModified(), bytes.NewReader(source))
+}
+
+// stripFrontmatter returns the body of the markdown source, without the
+// frontmatter fenced off by --- lines at the start.
+func stripFrontmatter(source []byte) []byte {
+	first, rest, _ := bytes.Cut(source, []byte("\n"))
+	if string(bytes.TrimSpace(first)) != "---" {
+		return source
+	}
+	for {
+		line, after, found := bytes.Cut(rest, []byte("\n"))
+		if !found {
+			return source
+		}
+		rest = after
+		if string(bytes.TrimSpace(line)) == "---" {
+			return bytes.TrimLeft(rest, "\r\n")
+		}
+	}
+}
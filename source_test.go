@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestStripFrontmatter(t *testing.T) {
+	for source, want := range map[string]string{
+		"---\ntitle: Hi\n---\n\nBody\n":     "Body\n",
+		"---\r\ntitle: Hi\r\n---\r\nBody\n": "Body\n",
+		"No frontmatter\n---\n":             "No frontmatter\n---\n",
+		"---\ntitle: Unterminated\n":        "---\ntitle: Unterminated\n",
+		"----\ntitle: Hi\n---\nBody\n":      "----\ntitle: Hi\n---\nBody\n",
+	} {
+		if got := string(stripFrontmatter([]byte(source))); got != want {
+			t.Errorf("stripFrontmatter(%q) = %q, want %q", source, got, want)
+		}
+	}
+}
+
+func TestServeMarkdown(t *testing.T) {
+	fsys := testFS()
+	fsys["posts/future-post.md"] = &fstest.MapFile{Data: []byte(`---
+title: "Future Post"
+published: "` + time.Now().Add(24*time.Hour).Format(time.RFC3339) + `"
+---
+
+Not out yet.
+`)}
+	cfg := *testConfig
+	cfg.SourceFrontmatter = false
+	srv := newTestServer(t, &cfg, fsys)
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/blog/first-post.md", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /blog/first-post.md = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got, want := rec.Body.String(), "Hello from the first post.\n"; got != want {
+		t.Errorf("GET /blog/first-post.md without frontmatter = %q, want %q", got, want)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/markdown; charset=utf-8" {
+		t.Errorf("Content-Type = %s", got)
+	}
+	if got := rec.Header().Get("Last-Modified"); got == "" {
+		t.Error("Last-Modified isn't set")
+	}
+
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/blog/future-post.md", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /blog/future-post.md = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
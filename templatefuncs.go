@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"time"
+)
+
+// templateFuncs are the functions available to every template.
+func templateFuncs(cfg *Config, assets *assetManifest) template.FuncMap {
+	return template.FuncMap{
+		"asset":      assets.url,
+		"site":       func() *Config { return cfg },
+		"formatDate": formatDate,
+		"isoDate":    isoDate,
+		"themes":     func() []string { return themes },
+		"highlightStylesheets": func(theme string) ([]stylesheet, error) {
+			return highlightStylesheets(assets, theme)
+		},
+		"relativeTime": func(t time.Time) string {
+			return relativeTime(t, time.Now())
+		},
+		// sectionURL is the permalink to the heading with id in a post.
+		"sectionURL": func(slug, id string) string {
+			return cfg.BaseURL + "/blog/" + slug + "#" + id
+		},
+	}
+}
+
+// formatDate formats t the way dates are shown throughout the site.
+func formatDate(t time.Time) string {
+	return t.Format("Jan 02 2006")
+}
+
+// isoDate formats t for the datetime attribute of a <time> element.
+func isoDate(t time.Time) string {
+	return t.Format(time.DateOnly)
+}
+
+// relativeTime describes how long before now t was, e.g. "3 days ago", or
+// how long after for times in the future. Months are treated as 30 days
+// and years as 365, which is plenty accurate for a rough description.
+func relativeTime(t, now time.Time) string {
+	d := now.Sub(t)
+	future := d < 0
+	if future {
+		d = -d
+	}
+
+	const day = 24 * time.Hour
+	var n int
+	var unit string
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		n, unit = int(d/time.Minute), "minute"
+	case d < day:
+		n, unit = int(d/time.Hour), "hour"
+	case d < 30*day:
+		n, unit = int(d/day), "day"
+	case d < 365*day:
+		n, unit = int(d/(30*day)), "month"
+	default:
+		n, unit = int(d/(365*day)), "year"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	if future {
+		return fmt.Sprintf("in %d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %s ago", n, unit)
+}
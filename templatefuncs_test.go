@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2024, time.March, 15, 12, 0, 0, 0, time.UTC)
+	const day = 24 * time.Hour
+	for _, tc := range []struct {
+		ago  time.Duration
+		want string
+	}{
+		{0, "just now"},
+		{59 * time.Second, "just now"},
+		{time.Minute, "1 minute ago"},
+		{59 * time.Minute, "59 minutes ago"},
+		{time.Hour, "1 hour ago"},
+		{23 * time.Hour, "23 hours ago"},
+		{day, "1 day ago"},
+		{29 * day, "29 days ago"},
+		{30 * day, "1 month ago"},
+		{364 * day, "12 months ago"},
+		{365 * day, "1 year ago"},
+		{3 * 365 * day, "3 years ago"},
+		{-2 * day, "in 2 days"},
+		{-30 * time.Second, "just now"},
+	} {
+		if got := relativeTime(now.Add(-tc.ago), now); got != tc.want {
+			t.Errorf("relativeTime(now - %s) = %q, want %q", tc.ago, got, tc.want)
+		}
+	}
+}
+
+func TestSectionURL(t *testing.T) {
+	sectionURL := templateFuncs(testConfig, &assetManifest{})["sectionURL"].(func(string, string) string)
+	if got, want := sectionURL("hello-world", "getting-started"), "https://morgangallant.com/blog/hello-world#getting-started"; got != want {
+		t.Errorf("sectionURL = %q, want %q", got, want)
+	}
+}
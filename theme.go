@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The theme is picked by the visitor and kept in a cookie, so that the
+// <html> tag can be given the right class server-side, rather than flashing
+// the wrong theme while a script works it out. "system" follows the
+// browser's prefers-color-scheme.
+const (
+	themeCookie  = "theme"
+	defaultTheme = "system"
+)
+
+var themes = []string{"system", "light", "dark"}
+
+type themeKey struct{}
+
+// themePreference reads the theme cookie into the request context, falling
+// back to defaultTheme if it's missing or holds an unknown theme.
+func themePreference(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		theme := defaultTheme
+		if c, err := r.Cookie(themeCookie); err == nil && slices.Contains(themes, c.Value) {
+			theme = c.Value
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), themeKey{}, theme)))
+	})
+}
+
+// themeFrom returns the theme of the visitor making r.
+func themeFrom(r *http.Request) string {
+	if theme, ok := r.Context().Value(themeKey{}).(string); ok {
+		return theme
+	}
+	return defaultTheme
+}
+
+// setTheme stores the theme posted by the toggle in the footer, then sends
+// the visitor back to the page they came from.
+func setTheme(w http.ResponseWriter, r *http.Request) {
+	if !parseForm(w, r) {
+		return
+	}
+	theme := r.PostFormValue("theme")
+	if !slices.Contains(themes, theme) {
+		http.Error(w, "unknown theme", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     themeCookie,
+		Value:    theme,
+		Path:     "/",
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+		Secure:   production(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, referrerPath(r), http.StatusSeeOther)
+}
+
+// referrerPath is the path of the page r was sent from, if it was sent from
+// this site, and / otherwise. Only the path is kept, so the redirect can't
+// be used to send visitors elsewhere.
+func referrerPath(r *http.Request) string {
+	u, err := url.Parse(r.Referer())
+	if err != nil || u.Host != r.Host || !strings.HasPrefix(u.Path, "/") {
+		return "/"
+	}
+	// Browsers read paths starting with // or /\ as protocol-relative urls.
+	if path := u.RequestURI(); !strings.HasPrefix(path, "//") && !strings.HasPrefix(path, "/\\") {
+		return path
+	}
+	return "/"
+}
+
+// stylesheet is linked to from the <head> of a page. It only applies when
+// Media, if set, matches.
+type stylesheet struct {
+	URL   string
+	Media string
+}
+
+// highlightAsset is the name of the code highlighting stylesheet for theme.
+func highlightAsset(theme string) string {
+	return "highlight-" + theme + ".css"
+}
+
+// highlightStylesheets returns the code highlighting stylesheets for theme,
+// and is exposed to templates. The system theme gets both the light and the
+// dark one, and leaves picking between them to the browser, the same as
+// color-scheme does for the rest of the page.
+func highlightStylesheets(assets *assetManifest, theme string) ([]stylesheet, error) {
+	if theme != "system" {
+		u, err := assets.url(highlightAsset(theme))
+		if err != nil {
+			return nil, err
+		}
+		return []stylesheet{{URL: u}}, nil
+	}
+	var sheets []stylesheet
+	for _, scheme := range []string{"light", "dark"} {
+		u, err := assets.url(highlightAsset(scheme))
+		if err != nil {
+			return nil, err
+		}
+		sheets = append(sheets, stylesheet{URL: u, Media: "(prefers-color-scheme: " + scheme + ")"})
+	}
+	return sheets, nil
+}
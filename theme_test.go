@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestThemeCookie(t *testing.T) {
+	srv := newTestServer(t, testConfig, testFS())
+	for _, tc := range []struct {
+		path, cookie, want string
+	}{
+		{"/", "", "theme-system"},
+		{"/", "dark", "theme-dark"},
+		{"/blog/first-post", "light", "theme-light"},
+		{"/", "neon", "theme-system"},
+		{"/nope", "dark", "theme-dark"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: themeCookie, Value: tc.cookie})
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		if body := rec.Body.String(); !strings.Contains(body, `<html class="`+tc.want+`">`) {
+			t.Errorf("GET %s with theme %q is missing class %s, got:\n%s", tc.path, tc.cookie, tc.want, body)
+		}
+	}
+}
+
+func TestSetTheme(t *testing.T) {
+	srv := newTestServer(t, testConfig, testFS())
+	for _, tc := range []struct {
+		theme, referer string
+		status         int
+		location       string
+	}{
+		{"dark", "http://example.com/blog/first-post?page=2", http.StatusSeeOther, "/blog/first-post?page=2"},
+		{"light", "", http.StatusSeeOther, "/"},
+		{"system", "https://elsewhere.com/blog", http.StatusSeeOther, "/"},
+		{"system", "http://example.com//elsewhere.com/blog", http.StatusSeeOther, "/"},
+		{"neon", "http://example.com/blog", http.StatusBadRequest, ""},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/theme", strings.NewReader(url.Values{"theme": {tc.theme}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Referer", tc.referer)
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("POST /theme %s from %q = %d, want %d", tc.theme, tc.referer, rec.Code, tc.status)
+			continue
+		} else if tc.status != http.StatusSeeOther {
+			continue
+		}
+		if got := rec.Header().Get("Location"); got != tc.location {
+			t.Errorf("POST /theme %s from %q redirected to %q, want %q", tc.theme, tc.referer, got, tc.location)
+		}
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != themeCookie || cookies[0].Value != tc.theme {
+			t.Errorf("POST /theme %s set cookies %v", tc.theme, cookies)
+		}
+	}
+}
+
+func TestHighlightStylesheets(t *testing.T) {
+	assets := &assetManifest{urls: map[string]string{
+		"highlight-light.css": "/highlight-light.css",
+		"highlight-dark.css":  "/highlight-dark.css",
+	}}
+	for theme, want := range map[string][]stylesheet{
+		"light": {{URL: "/highlight-light.css"}},
+		"dark":  {{URL: "/highlight-dark.css"}},
+		"system": {
+			{URL: "/highlight-light.css", Media: "(prefers-color-scheme: light)"},
+			{URL: "/highlight-dark.css", Media: "(prefers-color-scheme: dark)"},
+		},
+	} {
+		got, err := highlightStylesheets(assets, theme)
+		if err != nil {
+			t.Fatalf("highlightStylesheets(%q): %v", theme, err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("highlightStylesheets(%q) = %+v, want %+v", theme, got, want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/png"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+)
+
+// webManifest describes the site to browsers when it's installed or pinned,
+// see https://developer.mozilla.org/en-US/docs/Web/Manifest.
+type webManifest struct {
+	Name            string         `json:"name"`
+	ShortName       string         `json:"short_name"`
+	Description     string         `json:"description,omitempty"`
+	StartURL        string         `json:"start_url"`
+	Display         string         `json:"display"`
+	ThemeColor      string         `json:"theme_color,omitempty"`
+	BackgroundColor string         `json:"background_color,omitempty"`
+	Icons           []manifestIcon `json:"icons,omitempty"`
+}
+
+type manifestIcon struct {
+	Src   string `json:"src"`
+	Sizes string `json:"sizes"`
+	Type  string `json:"type"`
+}
+
+// registerIcons serves the favicon at /favicon.ico, where browsers look for
+// it when a page doesn't link to one, and generates site.webmanifest. Both
+// are registered after the public files, since they refer to the icon by its
+// fingerprinted url. Either is left alone if there's a file under public/
+// served at the same path already, which takes precedence.
+func registerIcons(mux *http.ServeMux, cfg *Config, fsys fs.FS, assets *assetManifest) error {
+	var icons []manifestIcon
+	if cfg.Icon != "" {
+		src, err := assets.url(cfg.Icon)
+		if err != nil {
+			return fmt.Errorf("checking SITE_ICON: %w", err)
+		}
+		iconPath := "public/" + cfg.Icon
+		content, err := fs.ReadFile(fsys, iconPath)
+		if err != nil {
+			return fmt.Errorf("reading icon: %w", err)
+		}
+		sizes, err := iconSizes(cfg.Icon, content)
+		if err != nil {
+			return fmt.Errorf("reading size of icon %s: %w", cfg.Icon, err)
+		}
+		icons = append(icons, manifestIcon{
+			Src:   src,
+			Sizes: sizes,
+			Type:  mime.TypeByExtension(path.Ext(cfg.Icon)),
+		})
+
+		if assets.prefix != "" || !assets.has("favicon.ico") {
+			tag := etag(content)
+			mux.HandleFunc("GET /favicon.ico", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", tag)
+				w.Header().Set("Cache-Control", publicCacheControl)
+				http.ServeFileFS(w, r, fsys, iconPath)
+			})
+		}
+	}
+
+	if assets.has("site.webmanifest") {
+		return nil
+	}
+
+	manifest, err := json.Marshal(webManifest{
+		Name:            cfg.SiteTitle,
+		ShortName:       cfg.SiteTitle,
+		Description:     cfg.Description,
+		StartURL:        "/",
+		Display:         "minimal-ui",
+		ThemeColor:      cfg.ThemeColor,
+		BackgroundColor: cfg.ThemeColor,
+		Icons:           icons,
+	})
+	if err != nil {
+		return fmt.Errorf("creating web manifest: %w", err)
+	}
+	assets.register(mux, "site.webmanifest", manifest, serveBytes("application/manifest+json", manifest))
+	return nil
+}
+
+// iconSizes is the sizes of an icon as listed in the web manifest. Vector
+// icons scale to any size, the rest are decoded to find out theirs.
+func iconSizes(name string, content []byte) (string, error) {
+	if path.Ext(name) == ".svg" {
+		return "any", nil
+	}
+	img, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%dx%d", img.Width, img.Height), nil
+}
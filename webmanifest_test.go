@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestIcons(t *testing.T) {
+	cfg := *testConfig
+	cfg.Icon = "favicon.svg"
+	cfg.ThemeColor = "#123456"
+	fsys := testFS()
+	fsys["public/favicon.svg"] = &fstest.MapFile{Data: []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`)}
+	srv := newTestServer(t, &cfg, fsys)
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/favicon.ico", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /favicon.ico = %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/svg+xml" {
+		t.Errorf("favicon content type = %q", ct)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != publicCacheControl {
+		t.Errorf("favicon cache control = %q", cc)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/site.webmanifest", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /site.webmanifest = %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/manifest+json" {
+		t.Errorf("manifest content type = %q", ct)
+	}
+	var manifest webManifest
+	if err := json.Unmarshal(rec.Body.Bytes(), &manifest); err != nil {
+		t.Fatalf("parsing manifest: %v", err)
+	}
+	if manifest.Name != cfg.SiteTitle || manifest.ThemeColor != "#123456" || len(manifest.Icons) != 1 {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	// The icon is referenced by its fingerprinted url.
+	icon := manifest.Icons[0]
+	if !strings.HasPrefix(icon.Src, "/favicon.") || icon.Src == "/favicon.svg" || icon.Sizes != "any" || icon.Type != "image/svg+xml" {
+		t.Errorf("unexpected icon %+v", icon)
+	}
+
+	cfg.Icon = "missing.svg"
+	if _, err := newServer(&cfg, fsys, slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil {
+		t.Error("expected an error for a missing icon")
+	}
+}
+
+func TestPublicIcons(t *testing.T) {
+	for _, tc := range []struct {
+		name, file, path, prefix string
+	}{
+		{"favicon", "public/favicon.ico", "/favicon.ico", ""},
+		{"favicon under a prefix", "public/favicon.ico", "/static/favicon.ico", "/static"},
+		{"manifest", "public/site.webmanifest", "/site.webmanifest", ""},
+		{"manifest under a prefix", "public/site.webmanifest", "/static/site.webmanifest", "/static"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := *testConfig
+			cfg.Icon = "favicon.svg"
+			cfg.AssetPrefix = tc.prefix
+			fsys := testFS()
+			fsys["public/favicon.svg"] = &fstest.MapFile{Data: []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`)}
+			fsys[tc.file] = &fstest.MapFile{Data: []byte("from public")}
+			srv := newTestServer(t, &cfg, fsys)
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			if rec.Code != http.StatusOK || rec.Body.String() != "from public" {
+				t.Errorf("GET %s = %d %q, want the file from public", tc.path, rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestIconSizes(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 192, 96))); err != nil {
+		t.Fatal(err)
+	}
+	if sizes, err := iconSizes("icon.png", buf.Bytes()); err != nil || sizes != "192x96" {
+		t.Errorf("iconSizes of png = %q, %v", sizes, err)
+	}
+	if _, err := iconSizes("icon.png", []byte("nope")); err == nil {
+		t.Error("expected an error for an undecodable icon")
+	}
+}
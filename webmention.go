@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// webmentionSourceLimit is how much of a source page is read when
+	// looking for the link to the target.
+	webmentionSourceLimit = 1 << 20
+	// webmentionsPerMinute is how many webmentions a single client can send
+	// per minute. It's stricter than the site-wide rate limit, since each of
+	// them makes the server fetch a page.
+	webmentionsPerMinute = 10
+)
+
+// webmention is a page (Source) which links to a page on this site (Target),
+// see https://www.w3.org/TR/webmention/.
+type webmention struct {
+	Source   string    `json:"source"`
+	Target   string    `json:"target"`
+	Received time.Time `json:"received"`
+}
+
+// webmentions receives webmentions, verifies them and keeps the accepted
+// ones in memory. If path is set they're also appended to it as json lines,
+// and loaded from it on startup.
+type webmentions struct {
+	site    *url.URL
+	path    string
+	client  *http.Client
+	limiter *rateLimiter
+	// exists reports whether a path on the site responds with a page.
+	exists func(path string) bool
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	accepted map[[2]string]webmention
+}
+
+func newWebmentions(cfg *Config, exists func(string) bool, logger *slog.Logger) (*webmentions, error) {
+	site, err := url.Parse(cfg.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing base url: %w", err)
+	}
+	wm := &webmentions{
+		site:     site,
+		path:     cfg.WebmentionsFile,
+		client:   publicHTTPClient(5 * time.Second),
+		limiter:  newRateLimiter(webmentionsPerMinute/60.0, webmentionsPerMinute),
+		exists:   exists,
+		logger:   logger,
+		accepted: make(map[[2]string]webmention),
+	}
+	if wm.path == "" {
+		return wm, nil
+	}
+	content, err := os.ReadFile(wm.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return wm, nil
+	} else if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		var m webmention
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", wm.path, err)
+		}
+		wm.accepted[[2]string{m.Source, m.Target}] = m
+	}
+	return wm, scanner.Err()
+}
+
+// ServeHTTP handles webmentions sent to the endpoint. The source is fetched
+// before responding, so an accepted webmention has already been verified.
+func (wm *webmentions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 4<<10)
+	if !parseForm(w, r) {
+		return
+	}
+	source, err := url.Parse(r.PostFormValue("source"))
+	if err != nil || (source.Scheme != "http" && source.Scheme != "https") || source.Host == "" {
+		http.Error(w, "source must be an http(s) url", http.StatusBadRequest)
+		return
+	}
+	target, err := url.Parse(r.PostFormValue("target"))
+	if err != nil || target.Scheme != wm.site.Scheme || target.Host != wm.site.Host || !wm.exists(target.Path) {
+		http.Error(w, "target must be a page on this site", http.StatusBadRequest)
+		return
+	} else if source.String() == target.String() {
+		http.Error(w, "source and target must be different", http.StatusBadRequest)
+		return
+	}
+
+	if ok, retryAfter := wm.limiter.allow(clientIP(r), time.Now()); !ok {
+		tooManyRequests(w, retryAfter)
+		return
+	}
+
+	if err := wm.verify(r.Context(), source, target); err != nil {
+		wm.logger.Info("rejected webmention", slog.String("source", source.String()), slog.String("error", err.Error()))
+		http.Error(w, "source doesn't link to target", http.StatusBadRequest)
+		return
+	}
+	if err := wm.accept(webmention{Source: source.String(), Target: target.String(), Received: time.Now()}); err != nil {
+		wm.logger.Error("failed to store webmention", slog.String("error", err.Error()))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// verify fetches source and checks that it links to target.
+func (wm *webmentions) verify(ctx context.Context, source, target *url.URL) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/html")
+	resp, err := wm.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching source responded with %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, webmentionSourceLimit))
+	if err != nil {
+		return fmt.Errorf("reading source: %w", err)
+	}
+	refs, err := linkTargets(string(body))
+	if err != nil {
+		return fmt.Errorf("finding links in source: %w", err)
+	}
+	for _, ref := range refs {
+		u, err := url.Parse(ref)
+		if err == nil && resp.Request.URL.ResolveReference(u).String() == target.String() {
+			return nil
+		}
+	}
+	return errors.New("no link to target found")
+}
+
+// accept stores m, replacing any earlier webmention with the same source
+// and target.
+func (wm *webmentions) accept(m webmention) error {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	if wm.path != "" {
+		line, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		f, err := os.OpenFile(wm.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(append(line, '\n')); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	wm.accepted[[2]string{m.Source, m.Target}] = m
+	return nil
+}
+
+// publicHTTPClient returns a client which refuses to connect to loopback,
+// private or link-local addresses, so that fetching a url supplied by a
+// visitor can't be used to poke at the network the server runs in.
+func publicHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+				ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+				return fmt.Errorf("refusing to connect to %s", host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
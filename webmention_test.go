@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebmentions(t *testing.T) {
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/links":
+			fmt.Fprint(w, `<p>Read <a href="https://morgangallant.com/blog/first-post">this</a>.</p>`)
+		case "/no-links":
+			fmt.Fprint(w, `<p>Nothing to see here.</p>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer source.Close()
+
+	cfg := *testConfig
+	cfg.WebmentionsFile = filepath.Join(t.TempDir(), "webmentions.jsonl")
+	srv := newTestServer(t, &cfg, testFS())
+	// The test server listens on loopback, which the real client refuses.
+	srv.webmentions.client = source.Client()
+
+	send := func(source, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/webmention", strings.NewReader(url.Values{
+			"source": {source},
+			"target": {target},
+		}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	for _, tc := range []struct {
+		source, target string
+		status         int
+	}{
+		{source.URL + "/links", "https://morgangallant.com/blog/first-post", http.StatusAccepted},
+		{source.URL + "/no-links", "https://morgangallant.com/blog/first-post", http.StatusBadRequest},
+		{source.URL + "/missing", "https://morgangallant.com/blog/first-post", http.StatusBadRequest},
+		{source.URL + "/links", "https://morgangallant.com/blog/does-not-exist", http.StatusBadRequest},
+		{source.URL + "/links", "https://example.com/blog/first-post", http.StatusBadRequest},
+		{"ftp://example.com/links", "https://morgangallant.com/blog/first-post", http.StatusBadRequest},
+		{"https://morgangallant.com/blog/first-post", "https://morgangallant.com/blog/first-post", http.StatusBadRequest},
+	} {
+		if rec := send(tc.source, tc.target); rec.Code != tc.status {
+			t.Errorf("webmention from %s to %s = %d, want %d: %s", tc.source, tc.target, rec.Code, tc.status, rec.Body.String())
+		}
+	}
+
+	reloaded, err := newWebmentions(&cfg, func(string) bool { return true }, srv.logger)
+	if err != nil {
+		t.Fatalf("reloading webmentions: %v", err)
+	}
+	if len(reloaded.accepted) != 1 {
+		t.Errorf("reloaded %d webmentions, want 1: %v", len(reloaded.accepted), reloaded.accepted)
+	}
+
+	srv.webmentions.limiter = newRateLimiter(1.0/60, 1)
+	if rec := send(source.URL+"/links", "https://morgangallant.com/blog/first-post"); rec.Code != http.StatusAccepted {
+		t.Errorf("first webmention after limiting = %d, want %d", rec.Code, http.StatusAccepted)
+	}
+	if rec := send(source.URL+"/links", "https://morgangallant.com/blog/first-post"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("second webmention after limiting = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestPublicHTTPClient(t *testing.T) {
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer local.Close()
+	if _, err := publicHTTPClient(time.Second).Get(local.URL); err == nil || !strings.Contains(err.Error(), "refusing to connect") {
+		t.Errorf("fetching a loopback url = %v, want it refused", err)
+	}
+}